@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"github.com/sirsean/marketmaker/config"
+)
+
+// serveAdmin answers status queries over HTTP while trading:
+//   /pnl     session and daily PnL summaries for each product
+//   /fills   every fill in the ledger
+//   /halt    whether quoting is halted; a POST halts it, canceling our
+//            orders as the config says unless cancel=true or false
+//   /resume  a POST resumes quoting
+func serveAdmin(addr string) {
+	log.Printf("admin listening on %v", addr)
+	if err := http.ListenAndServe(addr, adminHandler()); err != nil {
+		log.Printf("admin server failed: %v", err)
+	}
+}
+
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pnl", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, struct {
+			Session interface{} `json:"session"`
+			Days interface{} `json:"days"`
+		}{ledger.Session(), ledger.Days()})
+	})
+	mux.HandleFunc("/fills", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, ledger.Fills())
+	})
+	mux.HandleFunc("/halt", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			cancel := config.Get().Halt.CancelOrders
+			if c, err := strconv.ParseBool(r.FormValue("cancel")); err == nil {
+				cancel = c
+			}
+			haltTrading("halted from admin", cancel)
+		}
+		writeJson(w, haltStatus())
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "resume with a POST", http.StatusMethodNotAllowed)
+			return
+		}
+		resumeTrading()
+		writeJson(w, haltStatus())
+	})
+	return mux
+}
+
+func haltStatus() interface{} {
+	return struct {
+		Halted string `json:"halted"`
+	}{risk.Halted()}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v", err)
+	}
+}
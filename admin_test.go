@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"github.com/sirsean/marketmaker/config"
+	"github.com/sirsean/marketmaker/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"time"
+)
+
+type AdminTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (s *AdminTestSuite) SetupTest() {
+	ledger = model.NewLedger(time.Now())
+	risk = model.NewRiskManager(config.Defaults().Risk, ledger)
+	s.server = httptest.NewServer(adminHandler())
+}
+
+func (s *AdminTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *AdminTestSuite) post(path string) int {
+	resp, err := http.Post(s.server.URL + path, "application/x-www-form-urlencoded", nil)
+	assert.Nil(s.T(), err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (s *AdminTestSuite) TestHaltAndResume() {
+	assert.Equal(s.T(), http.StatusOK, s.post("/halt?cancel=false"))
+	assert.Equal(s.T(), "halted from admin", risk.Halted())
+	halted, cancel := risk.Breached()
+	assert.True(s.T(), halted)
+	assert.False(s.T(), cancel)
+
+	resp, err := http.Get(s.server.URL + "/resume")
+	assert.Nil(s.T(), err)
+	resp.Body.Close()
+	assert.Equal(s.T(), http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(s.T(), "halted from admin", risk.Halted())
+
+	assert.Equal(s.T(), http.StatusOK, s.post("/resume"))
+	assert.Equal(s.T(), "", risk.Halted())
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/sirsean/marketmaker/config"
+	"github.com/sirsean/marketmaker/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBacktestIsRepeatable(t *testing.T) {
+	dir := t.TempDir()
+	config.File = filepath.Join(dir, "marketmaker.gcfg")
+	defer func() { config.File = "" }()
+	assert.NoError(t, ioutil.WriteFile(config.File, []byte("[exchange]\nmode = paper\n[paper]\nusd = 1000\nbtc = 1\n"), 0644))
+	assert.NoError(t, config.Load())
+
+	recorder := model.NewRecorder(dir, "BTC-USD", 1 << 20, func() (*model.OrderBook, error) {
+		return &model.OrderBook{
+			Sequence: 1,
+			Bids: [][]string{{"100.00", "5.0", "b1"}},
+			Asks: [][]string{{"100.50", "5.0", "a1"}},
+		}, nil
+	})
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, msg := range []string{
+		`"type":"match","side":"buy","maker_order_id":"b1","taker_order_id":"t1","price":"99.98","size":"3","trade_id":1`,
+		`"type":"match","side":"sell","maker_order_id":"a1","taker_order_id":"t2","price":"100.60","size":"3","trade_id":2`,
+		`"type":"received","side":"buy","order_id":"b2","price":"100.10","size":"1"`,
+		`"type":"open","side":"buy","order_id":"b2","price":"100.10","remaining_size":"1"`,
+		`"type":"match","side":"buy","maker_order_id":"b2","taker_order_id":"t3","price":"100.05","size":"1","trade_id":3`,
+	} {
+		when := start.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano)
+		recorder.WriteMessage([]byte(fmt.Sprintf(`{%v,"product_id":"BTC-USD","sequence":%v,"time":"%v"}`, msg, i + 2, when)))
+	}
+	recorder.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	defer func() { paper = nil }()
+
+	first := runBacktest(files)
+	second := runBacktest(files)
+	assert.True(t, len(first.Fills) > 0)
+	assert.Equal(t, first.String(), second.String())
+}
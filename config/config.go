@@ -2,8 +2,14 @@ package config
 
 import (
 	"code.google.com/p/gcfg"
+	"fmt"
 	"log"
+	"math"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type Config struct {
@@ -12,30 +18,509 @@ type Config struct {
 		Secret string
 		Passphrase string
 	}
+	Strategy Strategy
+	// Product has a section for each product to trade, overriding any of
+	// the strategy parameters for it.
+	Product map[string]*Strategy
+	Risk Risk
+	Timing Timing
+	Expiry Expiry
+	RateLimit RateLimit
+	Volatility struct {
+		WindowSeconds []int
+	}
+	Exchange struct {
+		Mode string
+	}
+	Paper struct {
+		Usd float64
+		Btc float64
+		Fee float64
+	}
+	// PaperBalance starts the paper account with currencies other than
+	// USD and BTC.
+	PaperBalance map[string]*struct {
+		Amount float64
+	}
+	Record struct {
+		Dir string
+		MaxSizeMb int64
+	}
+	Replay struct {
+		Speed float64
+	}
+	// Store is the file our orders, fills and balances are kept in while
+	// trading live; an empty path keeps nothing, and neither does paper
+	// trading. KeepOrders leaves our orders resting when we shut down, to
+	// be picked up again on restart.
+	Store struct {
+		Path string
+		KeepOrders bool
+	}
+	// Admin serves status over HTTP while trading; it should only
+	// listen on localhost, and an empty address turns it off.
+	Admin struct {
+		Listen string
+	}
+	// Halt is the kill switch. Quoting stops while File exists, on SIGUSR1
+	// or on a POST to the admin server's /halt, and resumes once the file
+	// is removed, on SIGUSR2 or on a POST to /resume. CancelOrders has a
+	// halt cancel our resting orders too.
+	Halt struct {
+		File string
+		CancelOrders bool
+	}
+}
+
+// Strategy picks the quoting strategy and holds its parameters; each
+// strategy ignores the ones it doesn't use.
+type Strategy struct {
+	Name string
+	Product string
+	Size float64
+	Step float64
+	// StepBps and BandBps, when set, replace Step and Band with the same
+	// amounts in basis points of the price, so they mean the same for
+	// every product.
+	StepBps float64
+	BandBps float64
+	// Allocation is the share of each of its currencies' balances the
+	// product may quote with. Products without one split whatever the
+	// others leave evenly.
+	Allocation float64
+	// ladder
+	Band float64
+	Cycle int
+	// skew
+	Gamma float64
+	Kappa float64
+	Horizon float64
+	Target float64
+	Levels int
+}
+
+type Risk struct {
+	// MaxQuotedFraction caps the size quoted on each side as a fraction
+	// of the account's total value.
+	MaxQuotedFraction float64
+	// The rest are hard limits that every order is checked against, and
+	// zero turns each one off. Positions and sizes are in the product's
+	// base currency, notional and losses in its quote currency.
+	MaxPosition float64
+	MaxOpenNotional float64
+	MaxOrderSize float64
+	// PriceCollar is how far from the mid an order may be priced, as a
+	// fraction of the mid.
+	PriceCollar float64
+	// Passing either loss limit halts quoting and cancels our orders.
+	MaxSessionLoss float64
+	MaxDailyLoss float64
+}
+
+// Timing holds how often the bot does its periodic work, in seconds.
+// StaleSeconds is how long a product's feed can go quiet before we pull
+// its quotes.
+type Timing struct {
+	AccountSeconds int
+	OrdersSeconds int
+	RefillSeconds int
+	PrintSeconds int
+	FillsSeconds int
+	StaleSeconds int
+}
+
+// Expiry is our dead man's switch: every order we place cancels itself on
+// the exchange after CancelAfter, which is "min", "hour" or "day", so our
+// quotes can't outlive the bot by much even if it's killed outright. An
+// empty CancelAfter leaves orders good till canceled. Orders older than
+// RefreshSeconds are replaced on the next refill, before they expire.
+type Expiry struct {
+	CancelAfter string
+	RefreshSeconds int
+}
+
+// RateLimit is how many REST requests a second we allow ourselves, in
+// bursts of up to Burst, on the private endpoints our orders and account
+// go through and on the public ones order book snapshots come from.
+// Cancels go ahead of everything else and new orders wait behind it; once
+// MaxQueued new orders are waiting, more are refused.
+type RateLimit struct {
+	PrivateRate float64
+	PrivateBurst int
+	PublicRate float64
+	PublicBurst int
+	MaxQueued int
+}
+
+// cancelAfterSeconds is how long the exchange lets an order with each
+// cancel-after setting rest.
+var cancelAfterSeconds = map[string]int{
+	"min": 60,
+	"hour": 60 * 60,
+	"day": 24 * 60 * 60,
+}
+
+// Defaults is the configuration the bot has always run with, which a
+// config file only needs to override in part.
+func Defaults() Config {
+	c := Config{}
+	c.Strategy = Strategy{
+		Name: "ladder",
+		Product: "BTC-USD",
+		Size: 0.01,
+		Step: 0.01,
+		Band: 0.04,
+		Cycle: 3,
+		Gamma: 1.0,
+		Kappa: 10.0,
+		Horizon: 60.0,
+		Target: 0.5,
+		Levels: 5,
+	}
+	c.Risk = Risk{
+		MaxQuotedFraction: 0.5,
+	}
+	c.Timing = Timing{
+		AccountSeconds: 3,
+		OrdersSeconds: 60,
+		RefillSeconds: 10,
+		PrintSeconds: 3,
+		FillsSeconds: 60,
+		StaleSeconds: 10,
+	}
+	c.Expiry = Expiry{
+		CancelAfter: "min",
+		RefreshSeconds: 40,
+	}
+	c.RateLimit = RateLimit{
+		PrivateRate: 5,
+		PrivateBurst: 10,
+		PublicRate: 3,
+		PublicBurst: 6,
+		MaxQueued: 20,
+	}
+	c.Exchange.Mode = "live"
+	c.Record.Dir = "."
+	c.Record.MaxSizeMb = 100
+	c.Store.Path = "marketmaker.db"
+	c.Admin.Listen = "127.0.0.1:8089"
+	c.Halt.File = "marketmaker.halt"
+	c.Halt.CancelOrders = true
+	return c
 }
 
+func (c Config) IsPaper() bool {
+	return c.Exchange.Mode == "paper"
+}
+
+// Products lists the products to trade: those with their own section, or
+// just the strategy's product if there are none.
+func (c Config) Products() []string {
+	if len(c.Product) == 0 {
+		return []string{c.Strategy.Product}
+	}
+	ids := make([]string, 0, len(c.Product))
+	for id := range c.Product {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Allocation returns the shares of its base and quote currencies'
+// balances a product may quote with.
+func (c Config) Allocation(productId string) (float64, float64) {
+	parts := strings.Split(productId, "-")
+	return c.share(productId, parts[0]), c.share(productId, parts[len(parts) - 1])
+}
+
+func (c Config) share(productId string, currency string) float64 {
+	if a := c.StrategyFor(productId).Allocation; a > 0 {
+		return a
+	}
+	allocated, unset := c.allocated(currency)
+	if unset == 0 {
+		return 0
+	}
+	return math.Max(0, 1 - allocated) / float64(unset)
+}
+
+// allocated adds up the allocations of the products that trade currency,
+// and counts those that don't set one.
+func (c Config) allocated(currency string) (float64, int) {
+	allocated, unset := 0.0, 0
+	for _, id := range c.Products() {
+		if !trades(id, currency) {
+			continue
+		}
+		if a := c.StrategyFor(id).Allocation; a > 0 {
+			allocated += a
+		} else {
+			unset++
+		}
+	}
+	return allocated, unset
+}
+
+// currencies lists every currency the products trade.
+func (c Config) currencies() []string {
+	seen := make(map[string]bool)
+	currencies := make([]string, 0)
+	for _, id := range c.Products() {
+		for _, currency := range strings.Split(id, "-") {
+			if !seen[currency] {
+				seen[currency] = true
+				currencies = append(currencies, currency)
+			}
+		}
+	}
+	return currencies
+}
+
+func trades(productId string, currency string) bool {
+	for _, c := range strings.Split(productId, "-") {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
+
+// StrategyFor returns the strategy parameters for a product, which are
+// the strategy section's with whatever the product's section sets.
+func (c Config) StrategyFor(productId string) Strategy {
+	s := c.Strategy
+	s.Product = productId
+	o, ok := c.Product[productId]
+	if !ok || o == nil {
+		return s
+	}
+	if o.Name != "" {
+		s.Name = o.Name
+	}
+	if o.Size != 0 {
+		s.Size = o.Size
+	}
+	if o.Step != 0 {
+		s.Step = o.Step
+	}
+	if o.StepBps != 0 {
+		s.StepBps = o.StepBps
+	}
+	if o.Allocation != 0 {
+		s.Allocation = o.Allocation
+	}
+	if o.Band != 0 {
+		s.Band = o.Band
+	}
+	if o.BandBps != 0 {
+		s.BandBps = o.BandBps
+	}
+	if o.Cycle != 0 {
+		s.Cycle = o.Cycle
+	}
+	if o.Gamma != 0 {
+		s.Gamma = o.Gamma
+	}
+	if o.Kappa != 0 {
+		s.Kappa = o.Kappa
+	}
+	if o.Horizon != 0 {
+		s.Horizon = o.Horizon
+	}
+	if o.Target != 0 {
+		s.Target = o.Target
+	}
+	if o.Levels != 0 {
+		s.Levels = o.Levels
+	}
+	return s
+}
+
+// KeepStartup returns c with the settings that only take effect at
+// startup put back to old's, for a reload, and names the ones that were
+// changed.
+func (c Config) KeepStartup(old Config) (Config, []string) {
+	changed := make([]string, 0)
+	if !reflect.DeepEqual(c.Products(), old.Products()) {
+		changed = append(changed, "products")
+		c.Strategy.Product = old.Strategy.Product
+		c.Product = old.Product
+	}
+	if c.Exchange != old.Exchange || c.Coinbase != old.Coinbase || c.Paper != old.Paper || !reflect.DeepEqual(c.PaperBalances(), old.PaperBalances()) {
+		changed = append(changed, "exchange")
+		c.Exchange, c.Coinbase, c.Paper, c.PaperBalance = old.Exchange, old.Coinbase, old.Paper, old.PaperBalance
+	}
+	if c.Store != old.Store {
+		changed = append(changed, "store")
+		c.Store = old.Store
+	}
+	if c.Admin != old.Admin {
+		changed = append(changed, "admin")
+		c.Admin = old.Admin
+	}
+	if c.Halt.File != old.Halt.File {
+		changed = append(changed, "halt file")
+		c.Halt.File = old.Halt.File
+	}
+	if c.Record != old.Record || c.Replay != old.Replay {
+		changed = append(changed, "record and replay")
+		c.Record, c.Replay = old.Record, old.Replay
+	}
+	return c, changed
+}
+
+// PaperBalances is what the paper account starts with, by currency.
+func (c Config) PaperBalances() map[string]float64 {
+	balances := map[string]float64{"USD": c.Paper.Usd, "BTC": c.Paper.Btc}
+	for currency, b := range c.PaperBalance {
+		if b != nil {
+			balances[strings.ToUpper(currency)] = b.Amount
+		}
+	}
+	return balances
+}
+
+func (c Config) Validate() error {
+	for _, id := range c.Products() {
+		if err := validateStrategy(c.StrategyFor(id)); err != nil {
+			return err
+		}
+	}
+	for _, currency := range c.currencies() {
+		if allocated, _ := c.allocated(currency); allocated > 1.000001 {
+			return fmt.Errorf("products are allocated %v of %v, more than all of it", allocated, currency)
+		}
+	}
+	if c.Risk.MaxQuotedFraction <= 0 || c.Risk.MaxQuotedFraction > 1 {
+		return fmt.Errorf("max quoted fraction must be above 0 and at most 1: %v", c.Risk.MaxQuotedFraction)
+	}
+	r := c.Risk
+	if r.MaxPosition < 0 || r.MaxOpenNotional < 0 || r.MaxOrderSize < 0 || r.PriceCollar < 0 || r.MaxSessionLoss < 0 || r.MaxDailyLoss < 0 {
+		return fmt.Errorf("risk limits can't be negative: %+v", r)
+	}
+	t := c.Timing
+	if t.AccountSeconds <= 0 || t.OrdersSeconds <= 0 || t.RefillSeconds <= 0 || t.PrintSeconds <= 0 || t.FillsSeconds <= 0 || t.StaleSeconds <= 0 {
+		return fmt.Errorf("timing intervals must be positive: %+v", t)
+	}
+	if e := c.Expiry; e.CancelAfter != "" {
+		lifetime, ok := cancelAfterSeconds[e.CancelAfter]
+		if !ok {
+			return fmt.Errorf("cancel after must be min, hour or day: %v", e.CancelAfter)
+		}
+		// an order can wait a whole refill past its refresh age before
+		// it's replaced
+		if e.RefreshSeconds <= 0 || e.RefreshSeconds + t.RefillSeconds >= lifetime {
+			return fmt.Errorf("refresh and refill seconds must add up to less than the %v seconds orders last: %+v", lifetime, e)
+		}
+	}
+	if c.Record.MaxSizeMb <= 0 {
+		return fmt.Errorf("recording max size must be positive: %v", c.Record.MaxSizeMb)
+	}
+	if l := c.RateLimit; l.PrivateRate <= 0 || l.PublicRate <= 0 || l.PrivateBurst < 1 || l.PublicBurst < 1 || l.MaxQueued < 1 {
+		return fmt.Errorf("rate limits must be positive: %+v", l)
+	}
+	for _, w := range c.Volatility.WindowSeconds {
+		if w <= 0 {
+			return fmt.Errorf("volatility windows must be positive: %v", w)
+		}
+	}
+	if c.Exchange.Mode != "live" && c.Exchange.Mode != "paper" {
+		return fmt.Errorf("unknown exchange mode: %v", c.Exchange.Mode)
+	}
+	return nil
+}
+
+func validateStrategy(s Strategy) error {
+	if s.Name != "ladder" && s.Name != "skew" {
+		return fmt.Errorf("%v: unknown strategy: %v", s.Product, s.Name)
+	}
+	if parts := strings.Split(s.Product, "-"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid product: %v", s.Product)
+	}
+	if s.Size < 0.01 {
+		return fmt.Errorf("strategy size must be at least 0.01: %v", s.Size)
+	}
+	if s.Step <= 0 || s.Band < 0 || s.Cycle < 1 {
+		return fmt.Errorf("invalid ladder step %v, band %v or cycle %v", s.Step, s.Band, s.Cycle)
+	}
+	if s.StepBps < 0 || s.BandBps < 0 {
+		return fmt.Errorf("invalid ladder step %v bps or band %v bps", s.StepBps, s.BandBps)
+	}
+	if s.Allocation < 0 || s.Allocation > 1 {
+		return fmt.Errorf("%v: allocation must be between 0 and 1: %v", s.Product, s.Allocation)
+	}
+	if s.Gamma <= 0 || s.Kappa <= 0 || s.Horizon <= 0 || s.Levels < 1 {
+		return fmt.Errorf("invalid skew gamma %v, kappa %v, horizon %v or levels %v", s.Gamma, s.Kappa, s.Horizon, s.Levels)
+	}
+	if s.Target < 0 || s.Target > 1 {
+		return fmt.Errorf("skew target must be between 0 and 1: %v", s.Target)
+	}
+	return nil
+}
+
+// File is the config file to load; it defaults to the system location.
+var File string
+
+var mu sync.RWMutex
 var cfg Config
 var loaded bool
 
 func Get() Config {
-	if !loaded {
-		Load()
+	mu.RLock()
+	c, ok := cfg, loaded
+	mu.RUnlock()
+	if !ok {
+		if err := Load(); err != nil {
+			log.Fatalf("Invalid config: %v", err)
+		}
+		return Get()
 	}
-	return cfg
+	return c
 }
 
-func Load() {
-	var file string
-	if len(os.Args) > 1 {
-		file = os.Args[1]
-	} else {
-		file = "/etc/marketmaker/marketmaker.gcfg"
+// Load reads the config file and makes it the current config if it's
+// valid.
+func Load() error {
+	c, err := Read()
+	if err != nil {
+		return err
 	}
+	Set(c)
+	return nil
+}
+
+// Set makes c the current config.
+func Set(c Config) {
+	mu.Lock()
+	cfg = c
+	loaded = true
+	mu.Unlock()
+}
+
+// Path is the config file to load.
+func Path() string {
+	if File == "" {
+		return "/etc/marketmaker/marketmaker.gcfg"
+	}
+	return File
+}
+
+// Read reads the config file over the defaults and validates it, without
+// touching the current config. A missing file just means running on the
+// defaults, which trading mustn't do.
+func Read() (Config, error) {
+	file := Path()
 	log.Printf("Loading from config file: %v", file)
-	err := gcfg.ReadFileInto(&cfg, file)
-	if err != nil {
-		log.Printf("Failed to read config: %v", err)
-	} else {
-		loaded = true
+	c := Defaults()
+	err := gcfg.ReadFileInto(&c, file)
+	if os.IsNotExist(err) {
+		log.Printf("No config file, using defaults")
+	} else if err != nil {
+		return c, err
+	}
+	if err := c.Validate(); err != nil {
+		return c, err
 	}
+	return c, nil
 }
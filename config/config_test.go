@@ -0,0 +1,110 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultsAreValid(t *testing.T) {
+	assert.NoError(t, Defaults().Validate())
+}
+
+func TestValidateRejectsBadValues(t *testing.T) {
+	c := Defaults()
+	c.Strategy.Name = "martingale"
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.Strategy.Size = 0.001
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.Risk.MaxQuotedFraction = 1.5
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.Timing.RefillSeconds = 0
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.Expiry.CancelAfter = "week"
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.Expiry.RefreshSeconds = 55
+	assert.Error(t, c.Validate())
+	c.Expiry.CancelAfter = "hour"
+	assert.NoError(t, c.Validate())
+	c.Expiry.CancelAfter = ""
+	c.Expiry.RefreshSeconds = 0
+	assert.NoError(t, c.Validate())
+
+	c = Defaults()
+	c.Record.MaxSizeMb = 0
+	assert.Error(t, c.Validate())
+
+	c = Defaults()
+	c.RateLimit.PrivateBurst = 0
+	assert.Error(t, c.Validate())
+}
+
+func TestLoadKeepsCurrentConfigWhenInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	File = filepath.Join(dir, "marketmaker.gcfg")
+	defer func() { File = "" }()
+
+	assert.NoError(t, ioutil.WriteFile(File, []byte("[strategy]\nname = skew\nlevels = 3\n"), 0644))
+	assert.NoError(t, Load())
+	assert.Equal(t, "skew", Get().Strategy.Name)
+	assert.Equal(t, 3, Get().Strategy.Levels)
+	assert.Equal(t, 0.01, Get().Strategy.Size)
+
+	assert.NoError(t, ioutil.WriteFile(File, []byte("[strategy]\nname = skew\nlevels = 0\n"), 0644))
+	assert.Error(t, Load())
+	assert.Equal(t, 3, Get().Strategy.Levels)
+}
+
+func TestAllocation(t *testing.T) {
+	c := Defaults()
+	base, quote := c.Allocation("BTC-USD")
+	assert.Equal(t, 1.0, base)
+	assert.Equal(t, 1.0, quote)
+
+	c.Product = map[string]*Strategy{"BTC-USD": {}, "ETH-USD": {Allocation: 0.7}, "ETH-BTC": {}}
+	assert.NoError(t, c.Validate())
+	base, quote = c.Allocation("BTC-USD")
+	assert.Equal(t, 0.5, base)
+	assert.InDelta(t, 0.3, quote, 0.000001)
+	base, quote = c.Allocation("ETH-BTC")
+	assert.InDelta(t, 0.3, base, 0.000001)
+	assert.Equal(t, 0.5, quote)
+
+	c.Product["BTC-USD"].Allocation = 0.5
+	assert.Error(t, c.Validate())
+}
+
+func TestKeepStartup(t *testing.T) {
+	old := Defaults()
+	c := Defaults()
+	c.Strategy.Size = 0.05
+	c.Exchange.Mode = "paper"
+	c.Coinbase.Key = "new"
+	c.Halt.File = "elsewhere.halt"
+	c.Product = map[string]*Strategy{"ETH-USD": {}}
+
+	kept, changed := c.KeepStartup(old)
+	assert.Equal(t, []string{"products", "exchange", "halt file"}, changed)
+	assert.Equal(t, 0.05, kept.Strategy.Size)
+	assert.Equal(t, "live", kept.Exchange.Mode)
+	assert.Equal(t, "", kept.Coinbase.Key)
+	assert.Equal(t, old.Halt.File, kept.Halt.File)
+	assert.Equal(t, old.Products(), kept.Products())
+
+	_, changed = old.KeepStartup(old)
+	assert.Equal(t, 0, len(changed))
+}
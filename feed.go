@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/gorilla/websocket"
+	"github.com/sirsean/marketmaker/model"
+)
+
+const (
+	feedUrl = "wss://ws-feed.exchange.coinbase.com"
+	feedStallTimeout = 30 * time.Second
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// subscription is the message that starts a feed. Subscriptions to the
+// user channel, which carries only our own orders' messages, are signed
+// like any other authenticated request.
+type subscription struct {
+	Type string `json:"type"`
+	ProductIds []string `json:"product_ids"`
+	Channels []string `json:"channels,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Key string `json:"key,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// publicSubscription subscribes to the full feed of productIds, with
+// heartbeats so we can tell a quiet product from a dead feed.
+func publicSubscription(productIds []string) func() (subscription, error) {
+	return func() (subscription, error) {
+		return subscription{
+			Type: "subscribe",
+			ProductIds: productIds,
+			Channels: []string{"full", "heartbeat"},
+		}, nil
+	}
+}
+
+// userSubscription subscribes to our own orders on productIds, signed
+// afresh for each connection since the exchange refuses old timestamps.
+// The heartbeat channel keeps the connection from looking stalled while
+// our orders are quiet.
+func userSubscription(productIds []string, key string, secret string, passphrase string) func() (subscription, error) {
+	return func() (subscription, error) {
+		sub := subscription{
+			Type: "subscribe",
+			ProductIds: productIds,
+			Channels: []string{"user", "heartbeat"},
+			Key: key,
+			Passphrase: passphrase,
+			Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		}
+		signature, err := sign(secret, sub.Timestamp + "GET" + "/users/self/verify")
+		if err != nil {
+			return sub, err
+		}
+		sub.Signature = signature
+		return sub, nil
+	}
+}
+
+// sign is the exchange's request signature: an HMAC-SHA256 of message
+// keyed by the decoded secret, base64 encoded.
+func sign(secret string, message string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// superviseFeed keeps a websocket subscription alive, reconnecting with
+// exponential backoff whenever the connection fails or stalls. Every raw
+// frame is passed to handle, and disconnected is called after each drop
+// since messages may have been missed.
+func superviseFeed(url string, sub func() (subscription, error), handle func(raw []byte), disconnected func()) {
+	delay := minReconnectDelay
+	for {
+		conn, err := subscribe(url, sub)
+		if err != nil {
+			log.Printf("failed to subscribe, retrying in %v: %v", delay, err)
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+		delay = minReconnectDelay
+
+		err = listenForMessages(conn, handle)
+		conn.Close()
+		disconnected()
+		log.Printf("feed disconnected: %v", err)
+	}
+}
+
+func subscribe(url string, sub func() (subscription, error)) (*websocket.Conn, error) {
+	subscription, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	wsHeaders := http.Header{}
+	conn, _, err := websocket.DefaultDialer.Dial(url, wsHeaders)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("connected!")
+
+	msg, _ := json.Marshal(subscription)
+	// never the signature fields, which carry our credentials
+	log.Printf("subscribing to %v on %v", subscription.Channels, subscription.ProductIds)
+	err = conn.WriteMessage(websocket.TextMessage, msg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	log.Printf("sent subscription")
+
+	return conn, nil
+}
+
+// listenForMessages passes frames from the connection to handle until it
+// fails, goes quiet for longer than feedStallTimeout, or reports an error.
+func listenForMessages(conn *websocket.Conn, handle func(raw []byte)) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(feedStallTimeout))
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var header struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(raw, &header) == nil && header.Type == "error" {
+			return errors.New(string(raw))
+		}
+		handle(raw)
+	}
+}
+
+// feedMessages is the handler for trading, which parses each frame and
+// sends it on to its product's market.
+func feedMessages(raw []byte) {
+	message := model.Message{}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		log.Printf("failed to parse message: %v", err)
+		return
+	}
+	//log.Printf(string(raw))
+	if m := marketFor(message.ProductId); m != nil {
+		m.msgs <- message
+	}
+}
+
+// feedDisconnected marks every book out of sync, so they get rebuilt from
+// fresh snapshots and quoting pauses until then.
+func feedDisconnected() {
+	for _, m := range markets {
+		m.book.SetSynced(false)
+	}
+}
+
+// userMessages is the handler for the user feed, which sends each of our
+// own orders' messages straight to its product's orders.
+func userMessages(raw []byte) {
+	message := model.Message{}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		log.Printf("failed to parse user message: %v", err)
+		return
+	}
+	if m := marketFor(message.ProductId); m != nil {
+		m.myOrders.HandleUserMessage(message)
+	}
+}
+
+// userDisconnected lists our orders again, since the user feed may have
+// missed some of their changes.
+func userDisconnected() {
+	for _, m := range markets {
+		go m.myOrders.RefreshOrders()
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type FeedTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	subscriptions chan subscription
+	frames []string
+	// conns has the server's end of the connection, for TearDownTest to
+	// close.
+	conns chan *websocket.Conn
+}
+
+// SetupTest starts a stand-in for the exchange's websocket feed, which
+// reports each subscription it gets and then sends frames.
+func (s *FeedTestSuite) SetupTest() {
+	s.subscriptions = make(chan subscription, 1)
+	s.frames = []string{
+		`{"type":"subscriptions","channels":[]}`,
+		`{"type":"received","product_id":"BTC-USD","order_id":"1","client_oid":"c1"}`,
+		`{"type":"done","product_id":"BTC-USD","order_id":"1","reason":"canceled"}`,
+	}
+	s.conns = make(chan *websocket.Conn, 1)
+	// the handler can outlive its test, so it only uses these and never
+	// the suite's fields, which the next test replaces
+	subscriptions, frames, conns := s.subscriptions, s.frames, s.conns
+	upgrader := websocket.Upgrader{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		select {
+			case conns <- conn:
+			default:
+		}
+		sub := subscription{}
+		if err := conn.ReadJSON(&sub); err != nil {
+			return
+		}
+		subscriptions <- sub
+		for _, frame := range frames {
+			conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		}
+	}))
+}
+
+func (s *FeedTestSuite) TearDownTest() {
+	select {
+		case conn := <-s.conns:
+			conn.Close()
+		default:
+	}
+	s.server.Close()
+}
+
+func (s *FeedTestSuite) url() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+func (s *FeedTestSuite) TestUserSubscription() {
+	secret := base64.StdEncoding.EncodeToString([]byte("secret"))
+	conn, err := subscribe(s.url(), userSubscription([]string{"BTC-USD"}, "key", secret, "pass"))
+	assert.Nil(s.T(), err)
+	defer conn.Close()
+
+	sub := <-s.subscriptions
+	assert.Equal(s.T(), []string{"user", "heartbeat"}, sub.Channels)
+	assert.Equal(s.T(), "key", sub.Key)
+	assert.Equal(s.T(), "pass", sub.Passphrase)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(sub.Timestamp + "GET/users/self/verify"))
+	assert.Equal(s.T(), base64.StdEncoding.EncodeToString(mac.Sum(nil)), sub.Signature)
+
+	types := make([]string, 0)
+	err = listenForMessages(conn, func(raw []byte) {
+		var header struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(raw, &header)
+		types = append(types, header.Type)
+	})
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), []string{"subscriptions", "received", "done"}, types)
+}
+
+func (s *FeedTestSuite) TestPublicSubscription() {
+	conn, err := subscribe(s.url(), publicSubscription([]string{"BTC-USD"}))
+	assert.Nil(s.T(), err)
+	defer conn.Close()
+
+	sub := <-s.subscriptions
+	assert.Equal(s.T(), []string{"BTC-USD"}, sub.ProductIds)
+	assert.Equal(s.T(), []string{"full", "heartbeat"}, sub.Channels)
+	assert.Equal(s.T(), "", sub.Signature)
+}
+
+func (s *FeedTestSuite) TestBadSecret() {
+	_, err := subscribe(s.url(), userSubscription([]string{"BTC-USD"}, "key", "not base64!", "pass"))
+	assert.NotNil(s.T(), err)
+}
+
+func TestFeedSuite(t *testing.T) {
+	suite.Run(t, new(FeedTestSuite))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"github.com/sirsean/marketmaker/config"
+)
+
+const haltFilePoll = time.Second
+
+// haltTrading stops new orders on every product, and cancels our resting
+// ones if cancel is set. The feeds and books keep running, so we can see
+// what's going on and resume without a restart.
+func haltTrading(reason string, cancel bool) {
+	risk.Halt(reason, cancel)
+	for _, m := range markets {
+		m.triggerRefill()
+	}
+}
+
+func resumeTrading() {
+	risk.Resume()
+	for _, m := range markets {
+		m.triggerRefill()
+	}
+}
+
+// liftHalt lifts only the halt given by reason, leaving any others.
+func liftHalt(reason string) {
+	risk.Lift(reason)
+	for _, m := range markets {
+		m.triggerRefill()
+	}
+}
+
+// watchHaltSignals halts on SIGUSR1 and resumes on SIGUSR2.
+func watchHaltSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	for sig := range c {
+		if sig == syscall.SIGUSR1 {
+			haltTrading("halted by SIGUSR1", config.Get().Halt.CancelOrders)
+		} else {
+			resumeTrading()
+		}
+	}
+}
+
+// watchHaltFile halts when the halt file appears and lifts that halt
+// when it's removed. Halts for other reasons stay.
+func watchHaltFile() {
+	path := config.Get().Halt.File
+	reason := fmt.Sprintf("halt file %v exists", path)
+	present := false
+	for {
+		_, err := os.Stat(path)
+		exists := path != "" && err == nil
+		if exists && !present {
+			haltTrading(reason, config.Get().Halt.CancelOrders)
+		} else if !exists && present {
+			liftHalt(reason)
+		}
+		present = exists
+		time.Sleep(haltFilePoll)
+	}
+}
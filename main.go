@@ -1,209 +1,339 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
-	"net/http"
-	"github.com/gorilla/websocket"
 	"github.com/sirsean/marketmaker/config"
 	"github.com/sirsean/marketmaker/model"
 	exchange "github.com/preichenberger/go-coinbase-exchange"
 	"os/signal"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
-var client *exchange.Client
-var myOrders *model.MyOrders
-var book *model.LocalBook
-var msgChan chan model.Message
-var buyChan chan *model.Order
-var sellChan chan *model.Order
+var client model.Exchange
+var paper *model.PaperExchange
+var scheduler *model.Scheduler
+var account *model.Account
+var ledger *model.Ledger
+var store *model.Store
+var risk *model.RiskManager
+var markets []*market
 var sigChan chan os.Signal
-var bidChangeChan chan *model.Order
-var askChangeChan chan *model.Order
+var hupChan chan os.Signal
 
 func main() {
-	log.Printf("starting up")
-
-	client = exchange.NewClient(
-		config.Get().Coinbase.Secret,
-		config.Get().Coinbase.Key,
-		config.Get().Coinbase.Passphrase)
-
-	msgChan = make(chan model.Message)
-	buyChan = make(chan *model.Order)
-	sellChan = make(chan *model.Order)
-	sigChan = make(chan os.Signal)
-	bidChangeChan = make(chan *model.Order)
-	askChangeChan = make(chan *model.Order)
-	book = model.NewLocalBook(bidChangeChan, askChangeChan)
-	myOrders = model.NewMyOrders(client, book)
-
-	myOrders.RefreshAccount()
-	myOrders.RefreshOrders()
-	go myOrders.StartTicking()
-
-	signal.Notify(sigChan, os.Interrupt)
-	signal.Notify(sigChan, syscall.SIGTERM)
-	go func(c chan os.Signal, mo *model.MyOrders) {
-		<-c
-		mo.CancelAllOrders()
-		os.Exit(1)
-	}(sigChan, myOrders)
+	mode := "trade"
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "trade" || args[0] == "record" || args[0] == "replay" || args[0] == "backtest") {
+		mode = args[0]
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		config.File = args[0]
+	}
 
-	// subscribe
-	conn := subscribe()
-	defer conn.Close()
+	log.Printf("starting up: %v", mode)
+	switch mode {
+	case "record":
+		record()
+	case "replay":
+		if len(args) < 2 {
+			log.Fatalf("usage: %v replay <config> <recording>...", os.Args[0])
+		}
+		replay(args[1:])
+	case "backtest":
+		if len(args) < 2 {
+			log.Fatalf("usage: %v backtest <config> <recording>...", os.Args[0])
+		}
+		backtest(args[1:])
+	default:
+		trade()
+	}
+}
 
-	go listenForMessages(conn, msgChan)
-	go watchBuys(buyChan)
-	go watchSells(sellChan)
-	go watchBidChanges(bidChangeChan)
-	go watchAskChanges(askChangeChan)
+func trade() {
+	// the defaults trade live with no credentials
+	if _, err := os.Stat(config.Path()); err != nil {
+		log.Fatalf("trading needs a config file: %v", err)
+	}
+	productIds := config.Get().Products()
+	// paper fills and orders would mix with the live ones in the ledger
+	// and the risk checks, so paper trading keeps no store
+	if path := config.Get().Store.Path; path != "" && !config.Get().IsPaper() {
+		var err error
+		if store, err = model.OpenStore(path); err != nil {
+			log.Fatalf("failed to open store %v: %v", path, err)
+		}
+	}
+	setup(config.Get().IsPaper(), productIds)
+	for _, m := range markets {
+		go m.myOrders.StartTicking()
+		go m.watchFeed()
+	}
+	go superviseFeed(feedUrl, publicSubscription(productIds), feedMessages, feedDisconnected)
+	if paper == nil {
+		creds := config.Get().Coinbase
+		go superviseFeed(feedUrl, userSubscription(productIds, creds.Key, creds.Secret, creds.Passphrase), userMessages, userDisconnected)
+	}
+	go watchFills()
+	go watchHaltSignals()
+	go watchHaltFile()
+	if addr := config.Get().Admin.Listen; addr != "" {
+		go serveAdmin(addr)
+	}
+	run()
+}
 
-	initOrderBook()
-	printInfo()
+// replay drives the bot from recorded feed files against a paper
+// exchange, never the real one. Recordings are of a single product, the
+// first one configured.
+func replay(files []string) {
+	r := model.NewReplay(files, config.Get().Replay.Speed)
+	setup(true, replayProducts())
+	markets[0].fetchSnapshot = r.Snapshot
+	go markets[0].myOrders.StartTicking()
+	go func() {
+		if err := r.Run(markets[0].msgs); err != nil {
+			log.Printf("replay failed: %v", err)
+		}
+	}()
+	run()
+	log.Printf("replay finished")
+	markets[0].printInfo()
+}
 
-	myOrders.RefillBids()
-	myOrders.RefillAsks()
+// backtest replays recorded feed files as fast as possible against a
+// paper exchange, requoting synchronously on every book change instead of
+// on timers, and reports how the strategy would have done.
+func backtest(files []string) {
+	log.Printf("backtest finished\n%v", runBacktest(files))
+}
 
-	handleMessages()
+func runBacktest(files []string) model.BacktestReport {
+	r := model.NewReplay(files, 0)
+	setup(true, replayProducts())
+	markets[0].fetchSnapshot = r.Snapshot
+	tester := model.NewBacktest(paper, markets[0].book)
+	markets[0].tester = tester
+	go func() {
+		if err := r.Run(markets[0].msgs); err != nil {
+			log.Printf("backtest replay failed: %v", err)
+		}
+	}()
+	run()
+	return tester.Report()
 }
 
-func subscribe() *websocket.Conn {
-	url := "wss://ws-feed.exchange.coinbase.com"
-	wsHeaders := http.Header{}
-	conn, _, err := websocket.DefaultDialer.Dial(url, wsHeaders)
-	if err != nil {
-		log.Fatal("websocket failed to connect: %v", err)
+func replayProducts() []string {
+	productIds := config.Get().Products()
+	if len(productIds) > 1 {
+		log.Printf("replaying %v only", productIds[0])
 	}
-	log.Printf("connected!")
+	return productIds[:1]
+}
 
-	type Subscribe struct {
-		Type string `json:"type"`
-		ProductId string `json:"product_id"`
-	}
-	subscription := Subscribe{
-		Type: "subscribe",
-		ProductId: "BTC-USD",
+func setup(paperTrading bool, productIds []string) {
+	sigChan = make(chan os.Signal, 1)
+	hupChan = make(chan os.Signal, 1)
+	account = model.NewAccount()
+	ledger = model.NewLedger(time.Now())
+	risk = model.NewRiskManager(config.Get().Risk, ledger)
+
+	if paperTrading {
+		log.Printf("paper trading")
+		paper = model.NewPaperExchange(config.Get().PaperBalances(), config.Get().Paper.Fee)
+		client = paper
+	} else {
+		client = model.NewCoinbaseExchange(exchange.NewClient(
+			config.Get().Coinbase.Secret,
+			config.Get().Coinbase.Key,
+			config.Get().Coinbase.Passphrase))
 	}
-	msg, _ := json.Marshal(subscription)
-	log.Printf("m: %v", string(msg))
-	err = conn.WriteMessage(websocket.TextMessage, msg)
-	if err != nil {
-		log.Printf("failed to send message: %v", err)
+	// paper orders aren't rate limited, but the snapshots we download
+	// while paper trading are
+	scheduler = model.NewScheduler(client, config.Get().RateLimit)
+	if !paperTrading {
+		client = scheduler
 	}
-	log.Printf("sent subscription")
-
-	return conn
-}
 
-func initOrderBook() {
-	ob, err := model.DownloadOrderBook()
-	if err != nil {
-		log.Printf("failed to download order book: %v", err)
-		return
+	markets = make([]*market, 0, len(productIds))
+	for _, id := range productIds {
+		product, err := model.ParseProduct(id)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		m := newMarket(product)
+		if seconds := config.Get().Volatility.WindowSeconds; len(seconds) > 0 {
+			setVolatilityWindows(m.book, seconds)
+		}
+		if err := m.myOrders.Configure(config.Get()); err != nil {
+			log.Fatalf("%v", err)
+		}
+		m.myOrders.SetStore(store)
+		m.myOrders.SetLedger(ledger)
+		m.myOrders.SetRisk(risk)
+		if paper != nil {
+			paper.AddProduct(product, m.book)
+			paper.SetListener(product.Id, m.myOrders)
+			m.myOrders.SetFee(config.Get().Paper.Fee)
+		}
+		markets = append(markets, m)
 	}
-	log.Printf("downloaded order book. bids: %v, asks: %v", len(ob.Bids), len(ob.Asks))
 
-	for _, o := range ob.BidOrders() {
-		book.AddBid(o)
+	account.Refresh(client)
+	// the store is reconciled first, so the orders we adopt next are
+	// found under the client ids it already has them by
+	if store != nil {
+		loadStore()
 	}
-	for _, o := range ob.AskOrders() {
-		book.AddAsk(o)
+	for _, m := range markets {
+		m.myOrders.RefreshOrders()
 	}
-}
+	reconcileFills()
 
-func handleMessages() {
-	for msg := range msgChan {
-		//log.Printf("%v", msg.String())
-		if msg.IsReceived() {
-			o := msg.Order()
-			book.AddOrder(o)
-			myOrders.ReconcilePendingOrder(o)
-		} else if msg.IsOpen() {
-			if o, ok := book.GetOrder(msg.OrderId); ok {
-				if msg.IsBuy() {
-					book.AddBid(o)
-				} else if msg.IsSell() {
-					book.AddAsk(o)
-				}
-			}
-		} else if msg.IsDone() {
-			if o, ok := book.GetOrder(msg.OrderId); ok {
-				if msg.IsBuy() {
-					book.RemoveBid(o)
-				} else if msg.IsSell() {
-					book.RemoveAsk(o)
-				}
-				if msg.IsCanceled() {
-					myOrders.ReconcileCanceledOrder(o)
-				} else {
-					myOrders.ReconcileOrder(o)
-				}
-			}
-		} else if msg.IsMatch() {
-			_, _, taker, _ := book.HandleMatch(msg)
-			if msg.IsBuy() {
-				buyChan <- taker
-			} else if msg.IsSell() {
-				sellChan <- taker
+	signal.Notify(sigChan, os.Interrupt)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	go func(c chan os.Signal) {
+		<-c
+		if store != nil && config.Get().Store.KeepOrders {
+			log.Printf("leaving our orders open")
+		} else {
+			for _, m := range markets {
+				m.myOrders.CancelAllOrders()
 			}
 		}
-	}
-}
+		store.Close()
+		os.Exit(1)
+	}(sigChan)
 
-func listenForMessages(conn *websocket.Conn, msgChan chan model.Message) {
-	for {
-		_, raw, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("failed to read message: %v", err)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func(c chan os.Signal) {
+		for range c {
+			reloadConfig()
 		}
-		message := model.Message{}
-		json.Unmarshal(raw, &message)
-		//log.Printf(string(raw))
-		msgChan <- message
-	}
+	}(hupChan)
 }
 
-func printInfo() {
-	log.Printf("%v", book)
-	log.Printf("MO: %v", myOrders)
+// loadStore picks up the fills we had before a restart and squares the
+// orders we thought were open with what the exchange says is. Balances
+// always come fresh from the exchange; the last snapshot is only logged,
+// to compare against.
+func loadStore() {
+	if last, err := store.LastBalances(); err == nil {
+		log.Printf("balances at %v: %v", last.Time, last.Balances)
+	}
+	fills, err := store.Fills()
+	if err != nil {
+		log.Fatalf("failed to load fills: %v", err)
+	}
+	ledger.Reconcile(fills)
+	log.Printf("loaded %v fills", len(fills))
+	orders, err := client.ListOrders()
+	if err != nil {
+		log.Printf("failed to list orders: %v", err)
+		return
+	}
+	store.ReconcileOrders(orders)
 }
 
-func watchBuys(c chan *model.Order) {
-	for o := range c {
-		log.Printf("BUY! %v", o.Price)
-		go refillMyOrders()
+// watchFills regularly catches the ledger up with the exchange's record
+// of our fills, which has the fees the feed leaves out, and snapshots
+// our balances.
+func watchFills() {
+	for {
+		time.Sleep(time.Duration(config.Get().Timing.FillsSeconds) * time.Second)
+		reconcileFills()
+		store.RecordBalances(account.Balances())
 	}
 }
 
-func watchSells(c chan *model.Order) {
-	for o := range c {
-		log.Printf("SELL! %v", o.Price)
-		go refillMyOrders()
+func reconcileFills() {
+	for _, m := range markets {
+		fills, err := client.RecentFills(m.product.Id)
+		if err != nil {
+			log.Printf("failed to list %v fills: %v", m.product, err)
+			continue
+		}
+		store.RecordFills(fills)
+		if added := ledger.Reconcile(fills); added > 0 {
+			log.Printf("found %v %v fills the feed missed", added, m.product)
+		}
 	}
 }
 
-func watchBidChanges(c chan *model.Order) {
-	for o := range c {
-		log.Printf("BID CHANGED: %v", o.Price)
-		go refillMyOrders()
+// run trades every market until their feeds end.
+func run() {
+	var wg sync.WaitGroup
+	wg.Add(len(markets))
+	for _, m := range markets {
+		go func(m *market) {
+			m.run()
+			wg.Done()
+		}(m)
 	}
+	wg.Wait()
 }
 
-func watchAskChanges(c chan *model.Order) {
-	for o := range c {
-		log.Printf("ASK CHANGED: %v", o.Price)
-		go refillMyOrders()
+// reloadConfig re-reads the config file and swaps the new strategy, risk
+// and timing parameters in without touching the feed, the book or our
+// resting orders. An invalid file is logged and the old config kept.
+// Settings that only take effect at startup keep their old values, and
+// are logged if they changed.
+func reloadConfig() {
+	old := config.Get()
+	if _, err := os.Stat(config.Path()); err != nil {
+		log.Printf("rejected config reload: %v", err)
+		return
+	}
+	cfg, err := config.Read()
+	if err != nil {
+		log.Printf("rejected config reload: %v", err)
+		return
+	}
+	cfg, changed := cfg.KeepStartup(old)
+	if err := cfg.Validate(); err != nil {
+		log.Printf("rejected config reload: %v", err)
+		return
+	}
+	// every market's settings are built before any are applied, so a
+	// reload one of them rejects changes nothing
+	settings := make([]model.Settings, len(markets))
+	for i, m := range markets {
+		s, err := m.myOrders.Prepare(cfg)
+		if err != nil {
+			log.Printf("rejected config reload for %v: %v", m.product, err)
+			return
+		}
+		settings[i] = s
+	}
+	for i, m := range markets {
+		m.myOrders.Apply(settings[i])
+	}
+	risk.Configure(cfg.Risk)
+	scheduler.Configure(cfg.RateLimit)
+	config.Set(cfg)
+	if len(changed) > 0 {
+		log.Printf("changes to the %v settings need a restart", strings.Join(changed, ", "))
+	}
+	if seconds := cfg.Volatility.WindowSeconds; len(seconds) > 0 && !reflect.DeepEqual(seconds, old.Volatility.WindowSeconds) {
+		for _, m := range markets {
+			setVolatilityWindows(m.book, seconds)
+		}
+	}
+	log.Printf("reloaded config: strategy %v, risk %+v, timing %+v", cfg.Strategy.Name, cfg.Risk, cfg.Timing)
+	for _, m := range markets {
+		m.triggerRefill()
 	}
 }
 
-func refillMyOrders() {
-	myOrders.ProtectBuys()
-	myOrders.ProtectAsks()
-	myOrders.RefillBids()
-	myOrders.RefillAsks()
-	printInfo()
+func setVolatilityWindows(book *model.LocalBook, seconds []int) {
+	windows := make([]time.Duration, len(seconds))
+	for i, s := range seconds {
+		windows[i] = time.Duration(s) * time.Second
+	}
+	book.SetVolatilityWindows(windows)
 }
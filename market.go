@@ -0,0 +1,339 @@
+package main
+
+import (
+	"log"
+	"github.com/sirsean/marketmaker/config"
+	"github.com/sirsean/marketmaker/model"
+	"time"
+)
+
+// market is everything we run for one product: its book, our orders on
+// it, and the feed messages routed to it.
+type market struct {
+	product model.Product
+	book *model.LocalBook
+	myOrders *model.MyOrders
+	msgs chan model.Message
+	buys chan *model.Order
+	sells chan *model.Order
+	bidChanges chan *model.Order
+	askChanges chan *model.Order
+	refills chan struct{}
+	fetchSnapshot func() (*model.OrderBook, error)
+	// tester follows the market through a backtest, which requotes after
+	// each message instead of in the background
+	tester *model.Backtest
+}
+
+func newMarket(product model.Product) *market {
+	m := &market{
+		product: product,
+		msgs: make(chan model.Message),
+		buys: make(chan *model.Order),
+		sells: make(chan *model.Order),
+		bidChanges: make(chan *model.Order),
+		askChanges: make(chan *model.Order),
+		refills: make(chan struct{}, 1),
+	}
+	m.book = model.NewLocalBook(m.bidChanges, m.askChanges)
+	m.book.SetTick(product.Tick)
+	m.myOrders = model.NewMyOrders(client, m.book, product, account)
+	m.fetchSnapshot = func() (ob *model.OrderBook, err error) {
+		err = scheduler.Public(func() error {
+			ob, err = model.DownloadOrderBook(product.Id)
+			return err
+		})
+		return
+	}
+	return m
+}
+
+// marketFor returns the market trading productId, or nil.
+func marketFor(productId string) *market {
+	for _, m := range markets {
+		if m.product.Id == productId {
+			return m
+		}
+	}
+	return nil
+}
+
+func (m *market) run() {
+	go m.watchBuys()
+	go m.watchSells()
+	go m.watchBidChanges()
+	go m.watchAskChanges()
+	go m.watchRefills()
+
+	pending := m.syncOrderBook(nil)
+	m.printInfo()
+
+	m.myOrders.RefillBids()
+	m.myOrders.RefillAsks()
+
+	m.handleMessages(pending)
+}
+
+// syncOrderBook rebuilds the local book from a fresh level-3 snapshot.
+// Messages that arrive while the snapshot downloads are buffered and
+// returned, to be applied on top of it like any others; those the
+// snapshot already includes are skipped then. It gives up if the feed
+// ends before a snapshot can be had.
+func (m *market) syncOrderBook(pending []model.Message) []model.Message {
+	m.book.SetSynced(false)
+	buffered := pending
+	msgs := m.msgs
+	snapshotChan := make(chan *model.OrderBook, 1)
+	errChan := make(chan error, 1)
+	go m.downloadOrderBook(snapshotChan, errChan)
+
+	var ob *model.OrderBook
+	delay := minReconnectDelay
+	for ob == nil {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				msgs = nil
+				continue
+			}
+			buffered = append(buffered, msg)
+		case ob = <-snapshotChan:
+		case err := <-errChan:
+			log.Printf("failed to download %v order book, retrying in %v: %v", m.product, delay, err)
+			if msgs == nil {
+				return nil
+			}
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			go m.downloadOrderBook(snapshotChan, errChan)
+		}
+	}
+	m.book.Load(ob)
+	log.Printf("loaded %v order book at sequence %v. bids: %v, asks: %v, buffered: %v", m.product, ob.Sequence, len(ob.Bids), len(ob.Asks), len(buffered))
+	m.book.SetSynced(true)
+	return buffered
+}
+
+func (m *market) downloadOrderBook(c chan *model.OrderBook, errs chan error) {
+	ob, err := m.fetchSnapshot()
+	if err != nil {
+		errs <- err
+	} else {
+		c <- ob
+	}
+}
+
+// handleMessages applies the pending messages and then the feed's,
+// resyncing the book whenever it falls out of sync.
+func (m *market) handleMessages(pending []model.Message) {
+	for {
+		var msg model.Message
+		if len(pending) > 0 {
+			msg, pending = pending[0], pending[1:]
+		} else if next, ok := <-m.msgs; ok {
+			msg = next
+		} else {
+			return
+		}
+		m.book.Touch()
+		bid, ask := m.book.BestBidPrice(), m.book.BestAskPrice()
+		if !m.book.IsSynced() || !m.applyMessage(msg) {
+			pending = m.syncOrderBook(append([]model.Message{msg}, pending...))
+			continue
+		}
+		if m.tester != nil {
+			// requote here rather than from the watchers, so the
+			// results don't depend on goroutine scheduling
+			if msg.IsMatch() || m.book.BestBidPrice() != bid || m.book.BestAskPrice() != ask {
+				m.myOrders.RefreshAccount()
+				m.requote()
+			}
+			m.tester.Mark()
+		}
+		ledger.Mark(m.product.Id, m.book.Mid())
+	}
+}
+
+// applyMessage applies a feed message to the local book, returning false
+// if the message shows that we've missed some and the book must be rebuilt.
+func (m *market) applyMessage(msg model.Message) bool {
+	book := m.book
+	myOrders := m.myOrders
+	if msg.Time != "" {
+		book.Advance(msg.ParsedTime())
+	}
+	if msg.IsHeartbeat() {
+		return true
+	}
+	switch book.CheckSequence(msg.Sequence) {
+	case model.SequenceStale:
+		return true
+	case model.SequenceGap:
+		log.Printf("%v sequence gap: expected %v, got %v", m.product, book.Sequence() + 1, msg.Sequence)
+		return false
+	}
+
+	//log.Printf("%v", msg.String())
+	if msg.IsReceived() {
+		o := msg.Order()
+		book.AddOrder(o)
+		myOrders.ReconcilePendingOrder(o)
+	} else if msg.IsOpen() {
+		if o, ok := book.GetOrder(msg.OrderId); ok {
+			if msg.IsBuy() {
+				book.AddBid(o)
+			} else if msg.IsSell() {
+				book.AddAsk(o)
+			}
+		}
+	} else if msg.IsDone() {
+		if o, ok := book.GetOrder(msg.OrderId); ok {
+			if paper != nil && msg.IsCanceled() {
+				paper.HandleCancel(msg, o)
+			}
+			if msg.IsBuy() {
+				book.RemoveBid(o)
+			} else if msg.IsSell() {
+				book.RemoveAsk(o)
+			}
+			if msg.IsCanceled() {
+				myOrders.ReconcileCanceledOrder(o)
+			} else {
+				myOrders.ReconcileOrder(o)
+			}
+		}
+	} else if msg.IsChange() {
+		if o, ok := book.GetOrder(msg.OrderId); ok && msg.NewSize != "" {
+			oldSize := o.Size
+			if msg.IsBuy() {
+				book.ChangeBid(o, msg.ParsedNewSize())
+			} else if msg.IsSell() {
+				book.ChangeAsk(o, msg.ParsedNewSize())
+			}
+			myOrders.ReconcileChangedOrder(o, oldSize)
+		}
+	} else if msg.IsMatch() {
+		for _, f := range myOrders.FillsFor(msg) {
+			myOrders.ReconcileFill(f)
+		}
+		_, _, taker, takerOk := book.HandleMatch(msg)
+		if paper != nil {
+			paper.HandleMatch(msg)
+		}
+		if !takerOk {
+			// we joined the feed after the taker was received
+		} else if msg.IsBuy() {
+			m.buys <- taker
+		} else if msg.IsSell() {
+			m.sells <- taker
+		}
+	}
+	book.SetSequence(msg.Sequence)
+	return true
+}
+
+// watchFeed keeps checking that the feed hasn't gone stale.
+func (m *market) watchFeed() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	stale := false
+	for range ticker.C {
+		stale = m.checkFeed(stale, time.Duration(config.Get().Timing.StaleSeconds) * time.Second)
+	}
+}
+
+// checkFeed pulls our quotes once the feed has been quiet for longer than
+// threshold, and returns whether it's stale. The book is marked out of
+// sync, which stops any refills until the feed is back and the book has
+// been rebuilt from a fresh snapshot.
+func (m *market) checkFeed(stale bool, threshold time.Duration) bool {
+	age := m.book.SinceTouched()
+	if !stale && age > threshold {
+		log.Printf("%v feed quiet for %v, pulling quotes", m.product, age)
+		m.book.SetSynced(false)
+		m.myOrders.CancelAllOrders()
+		return true
+	} else if stale && age <= threshold && m.book.IsSynced() {
+		log.Printf("%v feed is back", m.product)
+		m.triggerRefill()
+		return false
+	}
+	return stale
+}
+
+func (m *market) printInfo() {
+	log.Printf("%v %v", m.product, m.book)
+	for _, s := range m.book.TradeStats() {
+		log.Printf("%v %v: vol %0.6f, %0.3f trades/s, flow %0.4f %v/s", m.product, s.Window, s.Volatility, s.TradeRate, s.SignedVolume, m.product.Base)
+	}
+	log.Printf("MO: %v", m.myOrders)
+	for _, s := range ledger.Session() {
+		if s.ProductId == m.product.Id {
+			log.Printf("PNL: %v", s)
+		}
+	}
+}
+
+func (m *market) watchBuys() {
+	for o := range m.buys {
+		log.Printf("%v BUY! %v", m.product, o.Price)
+		m.triggerRefill()
+	}
+}
+
+func (m *market) watchSells() {
+	for o := range m.sells {
+		log.Printf("%v SELL! %v", m.product, o.Price)
+		m.triggerRefill()
+	}
+}
+
+func (m *market) watchBidChanges() {
+	for o := range m.bidChanges {
+		log.Printf("%v BID CHANGED: %v", m.product, o.Price)
+		m.triggerRefill()
+	}
+}
+
+func (m *market) watchAskChanges() {
+	for o := range m.askChanges {
+		log.Printf("%v ASK CHANGED: %v", m.product, o.Price)
+		m.triggerRefill()
+	}
+}
+
+// triggerRefill requotes in the background. A burst of triggers while a
+// requote is running makes just one more. Backtests requote from
+// handleMessages instead.
+func (m *market) triggerRefill() {
+	if m.tester != nil {
+		return
+	}
+	select {
+	case m.refills <- struct{}{}:
+	default:
+	}
+}
+
+func (m *market) watchRefills() {
+	for range m.refills {
+		m.refillMyOrders()
+	}
+}
+
+func (m *market) refillMyOrders() {
+	m.requote()
+	m.printInfo()
+}
+
+// requote cancels the orders the strategy no longer wants and places the
+// ones it wants that we don't have.
+func (m *market) requote() {
+	m.myOrders.ProtectBuys()
+	m.myOrders.ProtectAsks()
+	m.myOrders.RefillBids()
+	m.myOrders.RefillAsks()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+	"github.com/sirsean/marketmaker/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type MarketTestSuite struct {
+	suite.Suite
+	m *market
+}
+
+func (s *MarketTestSuite) SetupTest() {
+	product, _ := model.ParseProduct("BTC-USD")
+	paper = model.NewPaperExchange(map[string]float64{"USD": 1000.0, "BTC": 1.0}, 0.0)
+	client = paper
+	account = model.NewAccount()
+	ledger = model.NewLedger(time.Now())
+	risk = nil
+	s.m = newMarket(product)
+	for _, c := range []chan *model.Order{s.m.bidChanges, s.m.askChanges} {
+		go func(c chan *model.Order) {
+			for range c {
+			}
+		}(c)
+	}
+	s.m.book.Load(&model.OrderBook{
+		Sequence: 1,
+		Bids: [][]string{{"100.00", "1.0", "b1"}},
+		Asks: [][]string{{"102.00", "1.0", "a1"}},
+	})
+	s.m.book.SetSynced(true)
+	paper.AddProduct(product, s.m.book)
+	paper.SetListener(product.Id, s.m.myOrders)
+}
+
+func (s *MarketTestSuite) TearDownTest() {
+	paper = nil
+	client = nil
+}
+
+func (s *MarketTestSuite) TestStaleFeedPullsQuotes() {
+	paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "sell", Price: 103.0, Size: 0.5})
+	s.m.myOrders.RefreshOrders()
+	s.m.book.Touch()
+	assert.False(s.T(), s.m.checkFeed(false, time.Minute))
+
+	time.Sleep(5 * time.Millisecond)
+	assert.True(s.T(), s.m.checkFeed(false, time.Millisecond))
+	assert.False(s.T(), s.m.book.IsSynced())
+	orders, _ := paper.ListOrders()
+	assert.Equal(s.T(), 0, len(orders))
+
+	// still stale until the book has been resynced
+	s.m.book.Touch()
+	assert.True(s.T(), s.m.checkFeed(true, time.Minute))
+	s.m.book.SetSynced(true)
+	assert.False(s.T(), s.m.checkFeed(true, time.Minute))
+}
+
+func (s *MarketTestSuite) TestHeartbeatsSkipTheBook() {
+	assert.True(s.T(), s.m.applyMessage(model.Message{Type: "heartbeat", ProductId: "BTC-USD", Sequence: 90}))
+	assert.Equal(s.T(), int64(1), s.m.book.Sequence())
+}
+
+func TestMarketSuite(t *testing.T) {
+	suite.Run(t, new(MarketTestSuite))
+}
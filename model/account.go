@@ -0,0 +1,103 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Account tracks the available balance of every currency we hold. All the
+// products we trade share it, so funds reserved for an order on one
+// product can't be promised to another that uses the same currency.
+type Account struct {
+	sync.Mutex
+	available map[string]float64
+	// balance includes what our orders hold, as of the last refresh.
+	balance map[string]float64
+}
+
+func NewAccount() *Account {
+	return &Account{
+		available: make(map[string]float64),
+		balance: make(map[string]float64),
+	}
+}
+
+// Refresh replaces the balances with what the exchange reports.
+func (a *Account) Refresh(client Exchange) {
+	accounts, err := client.GetAccounts()
+	if err != nil {
+		log.Printf("failed to get accounts: %v", err)
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	for _, acct := range accounts {
+		a.available[acct.Currency] = acct.Available
+		a.balance[acct.Currency] = acct.Balance
+	}
+}
+
+// Balance is everything we had of currency at the last refresh, held or
+// not.
+func (a *Account) Balance(currency string) float64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.balance[currency]
+}
+
+func (a *Account) Available(currency string) float64 {
+	a.Lock()
+	defer a.Unlock()
+	return a.available[currency]
+}
+
+// Balances returns a copy of every available balance.
+func (a *Account) Balances() map[string]float64 {
+	a.Lock()
+	defer a.Unlock()
+	balances := make(map[string]float64, len(a.available))
+	for c, b := range a.available {
+		balances[c] = b
+	}
+	return balances
+}
+
+// Add changes the available balance of currency by amount, which is
+// negative for funds going out.
+func (a *Account) Add(currency string, amount float64) {
+	a.Lock()
+	defer a.Unlock()
+	a.available[currency] += amount
+}
+
+// Reserve takes amount of currency out of the available balance for an
+// order, if there's enough of it.
+func (a *Account) Reserve(currency string, amount float64) bool {
+	a.Lock()
+	defer a.Unlock()
+	if a.available[currency] < amount {
+		return false
+	}
+	a.available[currency] -= amount
+	return true
+}
+
+func (a *Account) String() string {
+	a.Lock()
+	defer a.Unlock()
+	currencies := make([]string, 0, len(a.available))
+	for c := range a.available {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+	s := ""
+	for i, c := range currencies {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%v: %0.8f", c, a.available[c])
+	}
+	return s
+}
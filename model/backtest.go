@@ -0,0 +1,93 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Backtest follows a paper exchange through a replay, marking our
+// position to the book's mid after every message to track drawdown.
+type Backtest struct {
+	sync.Mutex
+	paper *PaperExchange
+	book *LocalBook
+	position Position
+	applied int
+	bought float64
+	sold float64
+	peak float64
+	maxDrawdown float64
+	mark float64
+}
+
+type BacktestReport struct {
+	Fills []Fill
+	Bought float64
+	Sold float64
+	Inventory float64
+	AvgCost float64
+	Mark float64
+	Realized float64
+	Unrealized float64
+	Fees float64
+	Pnl float64
+	MaxDrawdown float64
+}
+
+func NewBacktest(paper *PaperExchange, book *LocalBook) *Backtest {
+	return &Backtest{
+		paper: paper,
+		book: book,
+	}
+}
+
+// Mark applies any new fills and marks the position to the current mid.
+func (bt *Backtest) Mark() {
+	if !bt.book.IsSynced() {
+		return
+	}
+	fills := bt.paper.Fills()
+	bt.Lock()
+	defer bt.Unlock()
+	for _, f := range fills[bt.applied:] {
+		bt.position.Apply(f)
+		if f.Side == "buy" {
+			bt.bought += f.Size
+		} else {
+			bt.sold += f.Size
+		}
+	}
+	bt.applied = len(fills)
+	bt.mark = bt.book.Mid()
+	pnl := bt.position.Pnl(bt.mark)
+	bt.peak = math.Max(bt.peak, pnl)
+	bt.maxDrawdown = math.Max(bt.maxDrawdown, bt.peak - pnl)
+}
+
+func (bt *Backtest) Report() BacktestReport {
+	bt.Mark()
+	bt.Lock()
+	defer bt.Unlock()
+	return BacktestReport{
+		Fills: bt.paper.Fills(),
+		Bought: bt.bought,
+		Sold: bt.sold,
+		Inventory: bt.position.Size,
+		AvgCost: bt.position.AvgCost,
+		Mark: bt.mark,
+		Realized: bt.position.Realized,
+		Unrealized: bt.position.Unrealized(bt.mark),
+		Fees: bt.position.Fees,
+		Pnl: bt.position.Pnl(bt.mark),
+		MaxDrawdown: bt.maxDrawdown,
+	}
+}
+
+func (r BacktestReport) String() string {
+	fills := ""
+	for _, f := range r.Fills {
+		fills += fmt.Sprintf("\n  %v %v %0.8f @ %0.2f fee %0.4f", f.Time.Format("2006-01-02 15:04:05.000"), f.Side, f.Size, f.Price, f.Fee)
+	}
+	return fmt.Sprintf("fills: %v, bought: %0.8f, sold: %0.8f\ninventory: %0.8f @ %0.2f, mark: %0.2f\nrealized: $%0.2f, unrealized: $%0.2f, fees: $%0.2f\npnl: $%0.2f, max drawdown: $%0.2f%v", len(r.Fills), r.Bought, r.Sold, r.Inventory, r.AvgCost, r.Mark, r.Realized, r.Unrealized, r.Fees, r.Pnl, r.MaxDrawdown, fills)
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+func TestBacktestReport(t *testing.T) {
+	book := NewLocalBook(make(chan *Order, 100), make(chan *Order, 100))
+	book.Load(&OrderBook{
+		Sequence: 1,
+		Bids: [][]string{{"100.00", "1.0", "b1"}},
+		Asks: [][]string{{"102.00", "1.0", "a1"}},
+	})
+	book.SetSynced(true)
+	product, _ := ParseProduct("BTC-USD")
+	paper := NewPaperExchange(map[string]float64{"USD": 1000.0, "BTC": 0.0}, 0.0)
+	paper.AddProduct(product, book)
+	bt := NewBacktest(paper, book)
+
+	paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "buy", Price: 100.5, Size: 1.0})
+	paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "100.00", Size: "2.0"})
+	bt.Mark()
+	b1, _ := book.GetOrder("b1")
+	book.RemoveBid(b1)
+	book.AddBid(&Order{Id: "b2", Price: 98.0, Size: 1.0})
+	bt.Mark()
+
+	report := bt.Report()
+	assert.Equal(t, 1, len(report.Fills))
+	assert.Equal(t, 1.0, report.Bought)
+	assert.Equal(t, 1.0, report.Inventory)
+	assert.Equal(t, 100.0, report.Mark)
+	assert.Equal(t, -0.5, report.Pnl)
+	assert.Equal(t, 1.0, report.MaxDrawdown)
+}
@@ -0,0 +1,242 @@
+package model
+
+import (
+	"container/list"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const maxLevelHeight = 16
+
+// Level is a snapshot of a single price level on one side of the book.
+type Level struct {
+	Price float64
+	Size float64
+	Orders int
+}
+
+type priceLevel struct {
+	price float64
+	size float64
+	orders *list.List
+}
+
+func (l *priceLevel) snapshot() Level {
+	return Level{
+		Price: l.price,
+		Size: l.size,
+		Orders: l.orders.Len(),
+	}
+}
+
+type levelNode struct {
+	level *priceLevel
+	next []*levelNode
+}
+
+// BookSide holds one side of the book as a skiplist of price levels, best
+// price first, each level a FIFO queue of orders. Levels and orders are
+// also indexed by price and id so lookups and removals don't walk the list.
+type BookSide struct {
+	sync.RWMutex
+	better func(a, b float64) bool
+	head *levelNode
+	height int
+	levels map[float64]*levelNode
+	orders map[string]*list.Element
+	rand *rand.Rand
+}
+
+func newBookSide(better func(a, b float64) bool) *BookSide {
+	return &BookSide{
+		better: better,
+		head: &levelNode{next: make([]*levelNode, maxLevelHeight)},
+		height: 1,
+		levels: make(map[float64]*levelNode),
+		orders: make(map[string]*list.Element),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func NewBids() *BookSide {
+	return newBookSide(func(a, b float64) bool { return a > b })
+}
+
+func NewAsks() *BookSide {
+	return newBookSide(func(a, b float64) bool { return a < b })
+}
+
+func (s *BookSide) String() string {
+	s.RLock()
+	defer s.RUnlock()
+	return fmt.Sprintf("%v levels, %v orders", len(s.levels), len(s.orders))
+}
+
+// Best returns the order at the front of the queue at the best price.
+func (s *BookSide) Best() *Order {
+	s.RLock()
+	defer s.RUnlock()
+	if first := s.head.next[0]; first != nil {
+		return first.level.orders.Front().Value.(*Order)
+	} else {
+		return nil
+	}
+}
+
+func (s *BookSide) BestLevel() (Level, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	if first := s.head.next[0]; first != nil {
+		return first.level.snapshot(), true
+	} else {
+		return Level{}, false
+	}
+}
+
+// LevelAt returns the level at exactly the given price.
+func (s *BookSide) LevelAt(price float64) (Level, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	if node, ok := s.levels[price]; ok {
+		return node.level.snapshot(), true
+	} else {
+		return Level{}, false
+	}
+}
+
+// Ascend calls fn on each level from the best price outward until fn
+// returns false. The side is read-locked for the duration.
+func (s *BookSide) Ascend(fn func(Level) bool) {
+	s.RLock()
+	defer s.RUnlock()
+	for n := s.head.next[0]; n != nil; n = n.next[0] {
+		if !fn(n.level.snapshot()) {
+			return
+		}
+	}
+}
+
+func (s *BookSide) Contains(o *Order) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.orders[o.Id]
+	return ok
+}
+
+func (s *BookSide) Add(o *Order) {
+	s.Lock()
+	defer s.Unlock()
+	s.add(o)
+}
+
+// Reset replaces everything on this side with the given orders.
+func (s *BookSide) Reset(orders []*Order) {
+	s.Lock()
+	defer s.Unlock()
+	s.head = &levelNode{next: make([]*levelNode, maxLevelHeight)}
+	s.height = 1
+	s.levels = make(map[float64]*levelNode)
+	s.orders = make(map[string]*list.Element, len(orders))
+	for _, o := range orders {
+		s.add(o)
+	}
+}
+
+func (s *BookSide) Remove(o *Order) {
+	s.Lock()
+	defer s.Unlock()
+	s.remove(o.Id)
+}
+
+// Change resizes an order in place. It keeps its place in the queue, as
+// the exchange only ever shrinks resting orders.
+func (s *BookSide) Change(o *Order, size float64) {
+	s.Lock()
+	defer s.Unlock()
+	if e, ok := s.orders[o.Id]; ok {
+		node := s.levels[e.Value.(*Order).Price]
+		node.level.size += size - o.Size
+	}
+	o.Size = size
+}
+
+func (s *BookSide) add(o *Order) {
+	if _, ok := s.orders[o.Id]; ok {
+		s.remove(o.Id)
+	}
+	node, ok := s.levels[o.Price]
+	if !ok {
+		node = s.insertLevel(o.Price)
+	}
+	s.orders[o.Id] = node.level.orders.PushBack(o)
+	node.level.size += o.Size
+}
+
+func (s *BookSide) remove(id string) {
+	e, ok := s.orders[id]
+	if !ok {
+		return
+	}
+	o := e.Value.(*Order)
+	node := s.levels[o.Price]
+	node.level.orders.Remove(e)
+	node.level.size -= o.Size
+	delete(s.orders, id)
+	if node.level.orders.Len() == 0 {
+		s.deleteLevel(o.Price)
+	}
+}
+
+func (s *BookSide) randomHeight() int {
+	h := 1
+	for h < maxLevelHeight && s.rand.Intn(4) == 0 {
+		h++
+	}
+	return h
+}
+
+func (s *BookSide) insertLevel(price float64) *levelNode {
+	update := make([]*levelNode, maxLevelHeight)
+	n := s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for n.next[i] != nil && s.better(n.next[i].level.price, price) {
+			n = n.next[i]
+		}
+		update[i] = n
+	}
+	h := s.randomHeight()
+	for i := s.height; i < h; i++ {
+		update[i] = s.head
+	}
+	if h > s.height {
+		s.height = h
+	}
+	node := &levelNode{
+		level: &priceLevel{price: price, orders: list.New()},
+		next: make([]*levelNode, h),
+	}
+	for i := 0; i < h; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+	s.levels[price] = node
+	return node
+}
+
+func (s *BookSide) deleteLevel(price float64) {
+	n := s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for n.next[i] != nil && s.better(n.next[i].level.price, price) {
+			n = n.next[i]
+		}
+		if n.next[i] != nil && n.next[i].level.price == price {
+			n.next[i] = n.next[i].next[i]
+		}
+	}
+	for s.height > 1 && s.head.next[s.height - 1] == nil {
+		s.height--
+	}
+	delete(s.levels, price)
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"testing"
+)
+
+type BookSideTestSuite struct {
+	suite.Suite
+	bids *BookSide
+	asks *BookSide
+}
+
+func (s *BookSideTestSuite) SetupTest() {
+	s.bids = NewBids()
+	s.asks = NewAsks()
+}
+
+func (s *BookSideTestSuite) levels(side *BookSide) []Level {
+	levels := make([]Level, 0)
+	side.Ascend(func(l Level) bool {
+		levels = append(levels, l)
+		return true
+	})
+	return levels
+}
+
+func (s *BookSideTestSuite) TestBestPriceFirst() {
+	for i, p := range []float64{100.0, 102.0, 101.0} {
+		s.bids.Add(&Order{Id: fmt.Sprintf("b%v", i), Price: p, Size: 1.0})
+		s.asks.Add(&Order{Id: fmt.Sprintf("a%v", i), Price: p, Size: 1.0})
+	}
+	assert.Equal(s.T(), 102.0, s.bids.Best().Price)
+	assert.Equal(s.T(), 100.0, s.asks.Best().Price)
+	assert.Equal(s.T(), []Level{{102.0, 1.0, 1}, {101.0, 1.0, 1}, {100.0, 1.0, 1}}, s.levels(s.bids))
+	assert.Equal(s.T(), []Level{{100.0, 1.0, 1}, {101.0, 1.0, 1}, {102.0, 1.0, 1}}, s.levels(s.asks))
+}
+
+func (s *BookSideTestSuite) TestLevelIsFifo() {
+	first := &Order{Id: "1", Price: 100.0, Size: 0.5}
+	second := &Order{Id: "2", Price: 100.0, Size: 2.0}
+	s.bids.Add(first)
+	s.bids.Add(second)
+	assert.Equal(s.T(), first, s.bids.Best())
+	level, ok := s.bids.LevelAt(100.0)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), Level{100.0, 2.5, 2}, level)
+
+	s.bids.Change(first, 0.2)
+	level, _ = s.bids.LevelAt(100.0)
+	assert.InDelta(s.T(), 2.2, level.Size, 0.000001)
+	assert.Equal(s.T(), first, s.bids.Best())
+
+	s.bids.Remove(first)
+	assert.Equal(s.T(), second, s.bids.Best())
+	s.bids.Remove(second)
+	assert.Nil(s.T(), s.bids.Best())
+	_, ok = s.bids.LevelAt(100.0)
+	assert.False(s.T(), ok)
+}
+
+func (s *BookSideTestSuite) TestRandomUpdatesStaySorted() {
+	r := rand.New(rand.NewSource(1))
+	orders := make([]*Order, 0)
+	for i := 0; i < 2000; i++ {
+		if len(orders) > 0 && r.Intn(3) == 0 {
+			j := r.Intn(len(orders))
+			s.asks.Remove(orders[j])
+			orders = append(orders[:j], orders[j+1:]...)
+		} else {
+			o := &Order{Id: fmt.Sprintf("%v", i), Price: float64(r.Intn(200)) / 100, Size: 1.0}
+			s.asks.Add(o)
+			orders = append(orders, o)
+		}
+	}
+	levels := s.levels(s.asks)
+	count := 0
+	for i, l := range levels {
+		if i > 0 {
+			assert.True(s.T(), levels[i-1].Price < l.Price)
+		}
+		count += l.Orders
+	}
+	assert.Equal(s.T(), len(orders), count)
+}
+
+func TestBookSideSuite(t *testing.T) {
+	suite.Run(t, new(BookSideTestSuite))
+}
@@ -0,0 +1,111 @@
+package model
+
+import (
+	"math"
+)
+
+const TickSize = 0.01
+
+type Side string
+
+const (
+	Buy Side = "buy"
+	Sell Side = "sell"
+)
+
+// Impact describes what a market order of a given size would do to the
+// book if it were sent right now.
+type Impact struct {
+	Filled float64
+	Cost float64
+	Vwap float64
+	WorstPrice float64
+	Slippage float64
+}
+
+// restingSide returns the side of the book where orders on side rest.
+func (b *LocalBook) restingSide(side Side) *BookSide {
+	if side == Buy {
+		return b.bids
+	} else {
+		return b.asks
+	}
+}
+
+// DepthAt returns the total size resting at exactly price on side.
+func (b *LocalBook) DepthAt(side Side, price float64) float64 {
+	level, _ := b.restingSide(side).LevelAt(roundPlus(price, 8))
+	return level.Size
+}
+
+// DepthWithin returns the total size resting on side within the given
+// number of ticks of the best price, inclusive.
+func (b *LocalBook) DepthWithin(side Side, ticks int) float64 {
+	depth := 0.0
+	best := math.NaN()
+	within := float64(ticks) * b.tick + b.tick / 2
+	b.restingSide(side).Ascend(func(l Level) bool {
+		if math.IsNaN(best) {
+			best = l.Price
+		}
+		if math.Abs(l.Price - best) > within {
+			return false
+		}
+		depth += l.Size
+		return true
+	})
+	return depth
+}
+
+// TopLevels returns up to n levels from the best price outward on side.
+func (b *LocalBook) TopLevels(side Side, n int) []Level {
+	if n < 0 {
+		n = 0
+	}
+	levels := make([]Level, 0, n)
+	b.restingSide(side).Ascend(func(l Level) bool {
+		if len(levels) >= n {
+			return false
+		}
+		levels = append(levels, l)
+		return true
+	})
+	return levels
+}
+
+// MarketImpact walks the opposite side of the book to fill a market order
+// of size on side. Slippage is how much worse the average price is than
+// the touch; Filled is less than size if the book isn't deep enough.
+func (b *LocalBook) MarketImpact(side Side, size float64) Impact {
+	against := Sell
+	if side == Sell {
+		against = Buy
+	}
+	impact := Impact{}
+	touch := math.NaN()
+	b.restingSide(against).Ascend(func(l Level) bool {
+		if math.IsNaN(touch) {
+			touch = l.Price
+		}
+		fill := math.Min(l.Size, size - impact.Filled)
+		if fill <= 0 {
+			return false
+		}
+		impact.Filled += fill
+		impact.Cost += fill * l.Price
+		impact.WorstPrice = l.Price
+		return impact.Filled < size
+	})
+	if impact.Filled > 0 {
+		impact.Vwap = impact.Cost / impact.Filled
+		impact.Slippage = math.Abs(impact.Vwap - touch)
+	}
+	return impact
+}
+
+// VWAP returns the average price a market order of size on side would
+// pay, and whether the book is deep enough to fill it.
+func (b *LocalBook) VWAP(side Side, size float64) (float64, bool) {
+	impact := b.MarketImpact(side, size)
+	return impact.Vwap, impact.Filled >= size
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"time"
+)
+
+// Exchange is everything MyOrders needs from the exchange's REST API.
+type Exchange interface {
+	GetAccounts() ([]exchange.Account, error)
+	ListOrders() ([]exchange.Order, error)
+	CreateOrder(o *exchange.Order) (exchange.Order, error)
+	CancelOrder(id string) error
+	RecentFills(productId string) ([]Fill, error)
+}
+
+// CoinbaseExchange trades for real through the Coinbase Exchange API.
+type CoinbaseExchange struct {
+	client *exchange.Client
+}
+
+func NewCoinbaseExchange(client *exchange.Client) *CoinbaseExchange {
+	return &CoinbaseExchange{
+		client: client,
+	}
+}
+
+func (e *CoinbaseExchange) GetAccounts() ([]exchange.Account, error) {
+	return e.client.GetAccounts()
+}
+
+func (e *CoinbaseExchange) ListOrders() ([]exchange.Order, error) {
+	var page []exchange.Order
+	cursor := e.client.ListOrders()
+
+	orders := make([]exchange.Order, 0)
+	for cursor.HasMore {
+		if err := cursor.NextPage(&page); err != nil {
+			return nil, err
+		}
+
+		orders = append(orders, page...)
+	}
+	return orders, nil
+}
+
+func (e *CoinbaseExchange) CreateOrder(o *exchange.Order) (exchange.Order, error) {
+	return e.client.CreateOrder(o)
+}
+
+func (e *CoinbaseExchange) CancelOrder(id string) error {
+	return e.client.CancelOrder(id)
+}
+
+// RecentFills returns the latest page of our fills on productId.
+func (e *CoinbaseExchange) RecentFills(productId string) ([]Fill, error) {
+	var page []exchange.Fill
+	cursor := e.client.ListFills(exchange.ListFillsParams{ProductId: productId})
+	if err := cursor.NextPage(&page); err != nil {
+		return nil, err
+	}
+
+	fills := make([]Fill, len(page))
+	for i, f := range page {
+		fills[i] = Fill{
+			Time: time.Time(f.CreatedAt),
+			ProductId: f.ProductId,
+			TradeId: int64(f.TradeId),
+			OrderId: f.OrderId,
+			Side: f.Side,
+			Price: f.Price,
+			Size: f.Size,
+			Fee: f.Fee,
+		}
+	}
+	return fills, nil
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ledger keeps every fill of our orders we've heard about, from the feed
+// as they happen and from the exchange's fills endpoint after the fact,
+// once each by trade. Fills from the feed don't carry fees, so the
+// exchange's version of a fill replaces the feed's.
+type Ledger struct {
+	sync.Mutex
+	start time.Time
+	fills []*Fill
+	byTrade map[string]*Fill
+	marks map[string]float64
+}
+
+// LedgerSummary is how we did on one product over a session or a day.
+// Fees are what we paid and Rebates what we earned as maker; Pnl is net
+// of both. Position and AvgCost are as of the end of the period, and
+// Unrealized marks the position to the latest mid.
+type LedgerSummary struct {
+	ProductId string
+	Day string
+	Fills int
+	Bought float64
+	Sold float64
+	Volume float64
+	Position float64
+	AvgCost float64
+	Realized float64
+	Unrealized float64
+	Fees float64
+	Rebates float64
+	Pnl float64
+}
+
+func NewLedger(start time.Time) *Ledger {
+	return &Ledger{
+		start: start,
+		fills: make([]*Fill, 0),
+		byTrade: make(map[string]*Fill),
+		marks: make(map[string]float64),
+	}
+}
+
+func ledgerKey(f Fill) string {
+	return fmt.Sprintf("%v/%v/%v", f.ProductId, f.TradeId, f.OrderId)
+}
+
+// Record adds a fill from the feed, returning false if we already have it.
+func (l *Ledger) Record(f Fill) bool {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.byTrade[ledgerKey(f)]; ok {
+		return false
+	}
+	l.insert(f)
+	return true
+}
+
+// Reconcile merges fills reported by the exchange, which are the final
+// word on fees, and returns how many we didn't know about.
+func (l *Ledger) Reconcile(fills []Fill) int {
+	l.Lock()
+	defer l.Unlock()
+	added := 0
+	for _, f := range fills {
+		if known, ok := l.byTrade[ledgerKey(f)]; ok {
+			known.Fee = f.Fee
+			continue
+		}
+		l.insert(f)
+		added++
+	}
+	return added
+}
+
+// insert keeps the fills in time order. The caller must hold the lock.
+func (l *Ledger) insert(f Fill) {
+	i := sort.Search(len(l.fills), func(i int) bool {
+		return l.fills[i].Time.After(f.Time)
+	})
+	l.fills = append(l.fills, nil)
+	copy(l.fills[i + 1:], l.fills[i:])
+	l.fills[i] = &f
+	l.byTrade[ledgerKey(f)] = &f
+}
+
+// Mark sets the price unrealized PnL on productId is measured at.
+func (l *Ledger) Mark(productId string, price float64) {
+	l.Lock()
+	defer l.Unlock()
+	l.marks[productId] = price
+}
+
+// Fills returns every fill we know of, oldest first.
+func (l *Ledger) Fills() []Fill {
+	l.Lock()
+	defer l.Unlock()
+	fills := make([]Fill, len(l.fills))
+	for i, f := range l.fills {
+		fills[i] = *f
+	}
+	return fills
+}
+
+// Position is our position in productId over every fill we know of.
+func (l *Ledger) Position(productId string) Position {
+	l.Lock()
+	defer l.Unlock()
+	p := Position{}
+	for _, f := range l.fills {
+		if f.ProductId == productId {
+			p.Apply(*f)
+		}
+	}
+	return p
+}
+
+// Session summarizes each product's fills since the ledger started.
+func (l *Ledger) Session() []LedgerSummary {
+	return l.summarize(func(f *Fill) string {
+		if f.Time.Before(l.start) {
+			return ""
+		}
+		return "session"
+	})
+}
+
+// Days summarizes each product's fills by UTC day, oldest first.
+func (l *Ledger) Days() []LedgerSummary {
+	return l.summarize(func(f *Fill) string {
+		return f.Time.UTC().Format("2006-01-02")
+	})
+}
+
+// summarize runs every product's fills through a position in time order,
+// so the cost basis carries across periods, and totals each fill into
+// the period named by period, skipping those it names "".
+func (l *Ledger) summarize(period func(f *Fill) string) []LedgerSummary {
+	l.Lock()
+	defer l.Unlock()
+	positions := make(map[string]*Position)
+	summaries := make([]LedgerSummary, 0)
+	current := make(map[string]int)
+	for _, f := range l.fills {
+		p, ok := positions[f.ProductId]
+		if !ok {
+			p = &Position{}
+			positions[f.ProductId] = p
+		}
+		realized := p.Realized
+		p.Apply(*f)
+		name := period(f)
+		if name == "" {
+			continue
+		}
+		i, ok := current[f.ProductId]
+		if !ok || summaries[i].Day != name {
+			summaries = append(summaries, LedgerSummary{ProductId: f.ProductId, Day: name})
+			i = len(summaries) - 1
+			current[f.ProductId] = i
+		}
+		s := &summaries[i]
+		s.Fills++
+		if f.Side == "buy" {
+			s.Bought += f.Size
+		} else {
+			s.Sold += f.Size
+		}
+		s.Volume += f.Size * f.Price
+		s.Realized += p.Realized - realized
+		if f.Fee >= 0 {
+			s.Fees += f.Fee
+		} else {
+			s.Rebates -= f.Fee
+		}
+		s.Position = p.Size
+		s.AvgCost = p.AvgCost
+	}
+	for id, i := range current {
+		s := &summaries[i]
+		if mark, ok := l.marks[id]; ok && mark > 0 {
+			s.Unrealized = positions[id].Unrealized(mark)
+		}
+	}
+	for i := range summaries {
+		s := &summaries[i]
+		s.Pnl = s.Realized + s.Unrealized - s.Fees + s.Rebates
+	}
+	return summaries
+}
+
+func (s LedgerSummary) String() string {
+	return fmt.Sprintf("%v %v: %v fills, bought %0.8f, sold %0.8f, volume %0.2f, position %0.8f @ %v, realized %0.4f, unrealized %0.4f, fees %0.4f, rebates %0.4f, pnl %0.4f", s.ProductId, s.Day, s.Fills, s.Bought, s.Sold, s.Volume, s.Position, s.AvgCost, s.Realized, s.Unrealized, s.Fees, s.Rebates, s.Pnl)
+}
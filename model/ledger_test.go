@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLedgerDeduplicatesAndTakesExchangeFees(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLedger(start)
+	buy := Fill{Time: start.Add(time.Minute), ProductId: "BTC-USD", TradeId: 1, OrderId: "a", Side: "buy", Price: 100.0, Size: 1.0}
+	assert.True(t, l.Record(buy))
+	assert.False(t, l.Record(buy))
+
+	buy.Fee = 0.25
+	sell := Fill{Time: start.Add(2 * time.Minute), ProductId: "BTC-USD", TradeId: 2, OrderId: "b", Side: "sell", Price: 110.0, Size: 0.5, Fee: -0.1}
+	assert.Equal(t, 1, l.Reconcile([]Fill{sell, buy}))
+	assert.Equal(t, 2, len(l.Fills()))
+
+	l.Mark("BTC-USD", 120.0)
+	session := l.Session()
+	assert.Equal(t, 1, len(session))
+	s := session[0]
+	assert.Equal(t, 2, s.Fills)
+	assert.Equal(t, 155.0, s.Volume)
+	assert.Equal(t, 0.5, s.Position)
+	assert.Equal(t, 5.0, s.Realized)
+	assert.Equal(t, 10.0, s.Unrealized)
+	assert.Equal(t, 0.25, s.Fees)
+	assert.InDelta(t, 0.1, s.Rebates, 0.000001)
+	assert.InDelta(t, 14.85, s.Pnl, 0.000001)
+}
+
+func TestLedgerSummarizesByDay(t *testing.T) {
+	start := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	l := NewLedger(start)
+	l.Record(Fill{Time: start.Add(-time.Hour), ProductId: "BTC-USD", TradeId: 1, OrderId: "a", Side: "buy", Price: 100.0, Size: 1.0})
+	l.Record(Fill{Time: start.Add(time.Hour), ProductId: "BTC-USD", TradeId: 2, OrderId: "b", Side: "sell", Price: 104.0, Size: 1.0})
+	l.Record(Fill{Time: start.Add(time.Hour), ProductId: "ETH-USD", TradeId: 1, OrderId: "c", Side: "buy", Price: 10.0, Size: 2.0})
+
+	days := l.Days()
+	assert.Equal(t, 3, len(days))
+	assert.Equal(t, "2026-03-01", days[0].Day)
+	assert.Equal(t, 0.0, days[0].Realized)
+	assert.Equal(t, "2026-03-02", days[1].Day)
+	assert.Equal(t, "BTC-USD", days[1].ProductId)
+	assert.Equal(t, 4.0, days[1].Realized)
+	assert.Equal(t, "ETH-USD", days[2].ProductId)
+
+	session := l.Session()
+	assert.Equal(t, 2, len(session))
+	assert.Equal(t, 1, session[0].Fills)
+	assert.Equal(t, 4.0, session[0].Realized)
+}
@@ -4,19 +4,34 @@ import (
 	//"log"
 	"fmt"
 	"sync"
+	"time"
+)
+
+const (
+	SequenceOk = iota
+	SequenceStale
+	SequenceGap
 )
 
 type LocalBook struct {
 	sync.RWMutex
+	sequence int64
+	synced bool
 	book map[string]*Order
-	bids *Bids
-	asks *Asks
+	bids *BookSide
+	asks *BookSide
 	lastPrice float64
 	spread float64
 	bestBidPrice float64
 	bestAskPrice float64
 	bidChangeChan chan *Order
 	askChangeChan chan *Order
+	volatility *VolatilityEstimator
+	tick float64
+	touched time.Time
+	// clock is the exchange's time on the last message applied, which
+	// the trade stats decay to, so replayed trades aren't stale
+	clock time.Time
 }
 
 func NewLocalBook(bidChangeChan chan *Order, askChangeChan chan *Order) *LocalBook {
@@ -26,9 +41,128 @@ func NewLocalBook(bidChangeChan chan *Order, askChangeChan chan *Order) *LocalBo
 		asks: NewAsks(),
 		bidChangeChan: bidChangeChan,
 		askChangeChan: askChangeChan,
+		volatility: NewVolatilityEstimator([]time.Duration{time.Minute, 10 * time.Minute}),
+		tick: TickSize,
+		touched: time.Now(),
+	}
+}
+
+// SetTick sets the product's price increment, which depth queries count
+// ticks in. Call it before the book is used.
+func (b *LocalBook) SetTick(tick float64) {
+	b.tick = tick
+}
+
+func (b *LocalBook) Tick() float64 {
+	return b.tick
+}
+
+// SetVolatilityWindows starts the trade stats over with new windows.
+func (b *LocalBook) SetVolatilityWindows(windows []time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.volatility = NewVolatilityEstimator(windows)
+}
+
+// TradeStats returns the volatility and flow estimates as of the last
+// message applied, shortest window first.
+func (b *LocalBook) TradeStats() []TradeStats {
+	b.RLock()
+	volatility, now := b.volatility, b.clock
+	b.RUnlock()
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return volatility.Stats(now)
+}
+
+// Advance moves the book's clock up to t, the time on a message applied
+// to it.
+func (b *LocalBook) Advance(t time.Time) {
+	b.Lock()
+	defer b.Unlock()
+	if t.After(b.clock) {
+		b.clock = t
+	}
+}
+
+// Load replaces the contents of the book with a level-3 snapshot and
+// resets the sequence to the snapshot's, so only later messages apply.
+func (b *LocalBook) Load(ob *OrderBook) {
+	bids := ob.BidOrders()
+	asks := ob.AskOrders()
+	book := make(map[string]*Order, len(bids) + len(asks))
+	for _, o := range bids {
+		book[o.Id] = o
+	}
+	for _, o := range asks {
+		book[o.Id] = o
+	}
+	b.Lock()
+	b.book = book
+	b.sequence = ob.Sequence
+	b.Unlock()
+	b.bids.Reset(bids)
+	b.asks.Reset(asks)
+	b.recalculateSpread()
+}
+
+func (b *LocalBook) Sequence() int64 {
+	b.RLock()
+	defer b.RUnlock()
+	return b.sequence
+}
+
+// CheckSequence reports whether a message with the given sequence is the
+// next one to apply, is already reflected in the book, or follows a gap.
+func (b *LocalBook) CheckSequence(seq int64) int {
+	b.RLock()
+	defer b.RUnlock()
+	if seq <= b.sequence {
+		return SequenceStale
+	} else if seq == b.sequence + 1 {
+		return SequenceOk
+	} else {
+		return SequenceGap
 	}
 }
 
+func (b *LocalBook) SetSequence(seq int64) {
+	b.Lock()
+	defer b.Unlock()
+	b.sequence = seq
+}
+
+// IsSynced reports whether the book currently reflects the exchange; it
+// is false from a feed disconnect or gap until the next resync completes.
+func (b *LocalBook) IsSynced() bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.synced
+}
+
+func (b *LocalBook) SetSynced(synced bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.synced = synced
+}
+
+// Touch records that the feed is still delivering messages for the book,
+// heartbeats included.
+func (b *LocalBook) Touch() {
+	b.Lock()
+	defer b.Unlock()
+	b.touched = time.Now()
+}
+
+// SinceTouched is how long it's been since the feed last delivered a
+// message for the book.
+func (b *LocalBook) SinceTouched() time.Duration {
+	b.RLock()
+	defer b.RUnlock()
+	return time.Since(b.touched)
+}
+
 func (b *LocalBook) recalculateSpread() {
 	oldBestBidPrice := b.bestBidPrice
 	oldBestAskPrice := b.bestAskPrice
@@ -69,6 +203,13 @@ func (b *LocalBook) BestAskPrice() float64 {
 	return b.bestAskPrice
 }
 
+// Mid is halfway between the best bid and ask.
+func (b *LocalBook) Mid() float64 {
+	b.RLock()
+	defer b.RUnlock()
+	return (b.bestBidPrice + b.bestAskPrice) / 2
+}
+
 func (b *LocalBook) GetOrder(id string) (*Order, bool) {
 	b.RLock()
 	defer b.RUnlock()
@@ -112,34 +253,53 @@ func (b *LocalBook) RemoveAsk(o *Order) {
 	b.recalculateSpread()
 }
 
+func (b *LocalBook) ChangeBid(o *Order, size float64) {
+	b.bids.Change(o, size)
+	b.recalculateSpread()
+}
+
+func (b *LocalBook) ChangeAsk(o *Order, size float64) {
+	b.asks.Change(o, size)
+	b.recalculateSpread()
+}
+
+// HandleMatch applies a trade to the sizes of the maker and taker orders.
+// The match's side is the maker's, which tells us where it is resting.
 func (b *LocalBook) HandleMatch(msg Message) (*Order, bool, *Order, bool) {
 	maker, makerOk := b.GetOrder(msg.MakerOrderId)
 	taker, takerOk := b.GetOrder(msg.TakerOrderId)
+	size := msg.ParsedSize()
 	b.Lock()
 	b.lastPrice = msg.ParsedPrice()
+	volatility := b.volatility
+	b.Unlock()
+	takerSide := Buy
+	if msg.IsBuy() {
+		takerSide = Sell
+	}
+	volatility.Update(msg.ParsedPrice(), size, takerSide, msg.ParsedTime())
+	if msg.Time != "" {
+		b.Advance(msg.ParsedTime())
+	}
 	if makerOk {
-		maker.Size -= msg.ParsedSize()
+		if msg.IsBuy() {
+			b.bids.Change(maker, maker.Size - size)
+		} else if msg.IsSell() {
+			b.asks.Change(maker, maker.Size - size)
+		}
 	}
 	if takerOk {
-		taker.Size -= msg.ParsedSize()
+		b.Lock()
+		taker.Size -= size
+		b.Unlock()
 	}
-	b.Unlock()
 	return maker, makerOk, taker, takerOk
 }
 
 func (b *LocalBook) String() string {
-	var bid, bidSize, ask, askSize float64
-	bestBid := b.bids.Best()
-	if bestBid != nil {
-		bid = bestBid.Price
-		bidSize = bestBid.Size
-	}
-	bestAsk := b.asks.Best()
-	if bestAsk != nil {
-		ask = bestAsk.Price
-		askSize = bestAsk.Size
-	}
+	bid, _ := b.bids.BestLevel()
+	ask, _ := b.asks.BestLevel()
 	b.RLock()
 	defer b.RUnlock()
-	return fmt.Sprintf("last: %v, spread: %0.2f, bid: %0.4f@%0.2f, ask: %0.4f@%0.2f, book size: %v", b.lastPrice, b.spread, bidSize, bid, askSize, ask, len(b.book))
+	return fmt.Sprintf("last: %v, spread: %0.2f, bid: %0.4f@%0.2f, ask: %0.4f@%0.2f, book size: %v", b.lastPrice, b.spread, bid.Size, bid.Price, ask.Size, ask.Price, len(b.book))
 }
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type LocalBookTestSuite struct {
+	suite.Suite
+	book *LocalBook
+}
+
+func (s *LocalBookTestSuite) SetupTest() {
+	s.book = NewLocalBook(make(chan *Order, 100), make(chan *Order, 100))
+	s.book.Load(&OrderBook{
+		Sequence: 10,
+		Bids: [][]string{{"100.00", "1.0", "b1"}, {"101.00", "2.0", "b2"}},
+		Asks: [][]string{{"103.00", "1.5", "a1"}, {"102.00", "0.5", "a2"}},
+	})
+}
+
+func (s *LocalBookTestSuite) TestLoad() {
+	assert.Equal(s.T(), int64(10), s.book.Sequence())
+	assert.Equal(s.T(), 101.0, s.book.BestBidPrice())
+	assert.Equal(s.T(), 102.0, s.book.BestAskPrice())
+	_, ok := s.book.GetOrder("a1")
+	assert.True(s.T(), ok)
+}
+
+func (s *LocalBookTestSuite) TestCheckSequence() {
+	assert.Equal(s.T(), SequenceStale, s.book.CheckSequence(9))
+	assert.Equal(s.T(), SequenceStale, s.book.CheckSequence(10))
+	assert.Equal(s.T(), SequenceOk, s.book.CheckSequence(11))
+	assert.Equal(s.T(), SequenceGap, s.book.CheckSequence(12))
+	s.book.SetSequence(11)
+	assert.Equal(s.T(), SequenceOk, s.book.CheckSequence(12))
+}
+
+func (s *LocalBookTestSuite) TestChangeBid() {
+	o, _ := s.book.GetOrder("b2")
+	s.book.ChangeBid(o, 0.25)
+	assert.Equal(s.T(), 0.25, o.Size)
+	assert.Equal(s.T(), 101.0, s.book.BestBidPrice())
+}
+
+func (s *LocalBookTestSuite) TestDepth() {
+	s.book.AddBid(&Order{Id: "b3", Price: 101.0, Size: 0.5})
+	assert.Equal(s.T(), 2.5, s.book.DepthAt(Buy, 101.0))
+	assert.Equal(s.T(), 0.0, s.book.DepthAt(Buy, 100.5))
+	assert.Equal(s.T(), 2.5, s.book.DepthWithin(Buy, 0))
+	assert.Equal(s.T(), 2.5, s.book.DepthWithin(Buy, 99))
+	assert.Equal(s.T(), 3.5, s.book.DepthWithin(Buy, 100))
+	assert.Equal(s.T(), 2.0, s.book.DepthWithin(Sell, 100))
+	assert.Equal(s.T(), []Level{{102.0, 0.5, 1}}, s.book.TopLevels(Sell, 1))
+	assert.Equal(s.T(), 0, len(s.book.TopLevels(Sell, -1)))
+}
+
+func (s *LocalBookTestSuite) TestMarketImpact() {
+	impact := s.book.MarketImpact(Buy, 1.0)
+	assert.Equal(s.T(), 1.0, impact.Filled)
+	assert.Equal(s.T(), 102.5, impact.Vwap)
+	assert.Equal(s.T(), 103.0, impact.WorstPrice)
+	assert.Equal(s.T(), 0.5, impact.Slippage)
+
+	vwap, ok := s.book.VWAP(Sell, 2.0)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), 101.0, vwap)
+	_, ok = s.book.VWAP(Sell, 4.0)
+	assert.False(s.T(), ok)
+}
+
+func (s *LocalBookTestSuite) TestTradeStatsDecayToTheFeed() {
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, price := range []string{"101.00", "101.50", "101.00", "102.00"} {
+		s.book.HandleMatch(Message{
+			Type: "match",
+			Side: "sell",
+			Price: price,
+			Size: "0.1",
+			Time: start.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano),
+		})
+	}
+	stats := s.book.TradeStats()
+	assert.True(s.T(), stats[0].Volatility > 0)
+	assert.True(s.T(), stats[0].TradeRate > 0)
+
+	// an hour of feed later, the short window has all but faded
+	s.book.Advance(start.Add(time.Hour))
+	assert.InDelta(s.T(), 0.0, s.book.TradeStats()[0].TradeRate, 0.000001)
+}
+
+func TestLocalBookSuite(t *testing.T) {
+	suite.Run(t, new(LocalBookTestSuite))
+}
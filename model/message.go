@@ -3,6 +3,7 @@ package model
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 type Message struct {
@@ -39,6 +40,31 @@ func (m *Message) ParsedPrice() float64 {
 	return p
 }
 
+func (m *Message) ParsedNewSize() float64 {
+	s, _ := strconv.ParseFloat(m.NewSize, 64)
+	return s
+}
+
+func (m *Message) ParsedOldSize() float64 {
+	s, _ := strconv.ParseFloat(m.OldSize, 64)
+	return s
+}
+
+func (m *Message) ParsedRemainingSize() float64 {
+	s, _ := strconv.ParseFloat(m.RemainingSize, 64)
+	return s
+}
+
+// ParsedTime returns when the exchange sent the message, or now if the
+// message has no usable timestamp.
+func (m *Message) ParsedTime() time.Time {
+	t, err := time.Parse(time.RFC3339Nano, m.Time)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
 func (m *Message) IsReceived() bool {
 	return m.Type == "received"
 }
@@ -55,6 +81,16 @@ func (m *Message) IsMatch() bool {
 	return m.Type == "match"
 }
 
+func (m *Message) IsChange() bool {
+	return m.Type == "change"
+}
+
+// IsHeartbeat is true for the heartbeat channel's messages, which only
+// show that the feed is alive and don't touch the book.
+func (m *Message) IsHeartbeat() bool {
+	return m.Type == "heartbeat"
+}
+
 func (m *Message) IsBuy() bool {
 	return m.Side == "buy"
 }
@@ -2,6 +2,8 @@ package model
 
 import (
 	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"github.com/sirsean/marketmaker/config"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -12,517 +14,855 @@ import (
 
 type MyOrders struct {
 	sync.RWMutex
-	client *exchange.Client
+	client Exchange
 	book *LocalBook
-	availableBtc float64
-	availableUsd float64
-	pendingBuys map[string]exchange.Order
-	pendingSells map[string]exchange.Order
-	myBuys map[string]exchange.Order
-	mySells map[string]exchange.Order
-}
-
-func NewMyOrders(client *exchange.Client, book *LocalBook) *MyOrders {
+	product Product
+	account *Account
+	// orders has every order we're tracking by client id, and byId the
+	// ones the exchange has given an id.
+	orders map[string]*TrackedOrder
+	byId map[string]*TrackedOrder
+	strategy Strategy
+	// baseShare and quoteShare are the parts of the account's balances
+	// we may quote with.
+	baseShare float64
+	quoteShare float64
+	timing config.Timing
+	retime chan config.Timing
+	expiry config.Expiry
+	store *Store
+	ledger *Ledger
+	risk *RiskManager
+	// fee is the rate the exchange holds for the fee on our buys.
+	fee float64
+}
+
+// NewMyOrders quotes one product, drawing funds from an account that may
+// be shared with other products.
+func NewMyOrders(client Exchange, book *LocalBook, product Product, account *Account) *MyOrders {
 	return &MyOrders{
 		client: client,
 		book: book,
-		pendingBuys: make(map[string]exchange.Order),
-		pendingSells: make(map[string]exchange.Order),
-		myBuys: make(map[string]exchange.Order),
-		mySells: make(map[string]exchange.Order),
+		product: product,
+		account: account,
+		orders: make(map[string]*TrackedOrder),
+		byId: make(map[string]*TrackedOrder),
+		strategy: NewLadderStrategy(config.Defaults()),
+		baseShare: 1,
+		quoteShare: 1,
+		timing: config.Defaults().Timing,
+		retime: make(chan config.Timing, 1),
+		expiry: config.Defaults().Expiry,
+	}
+}
+
+// Configure picks the strategy for our product, the timing and our
+// orders' expiry from the config. It can be called again while running
+// to swap them in place; resting orders are left alone, and the next
+// refill cancels whichever ones the new strategy no longer wants.
+func (mo *MyOrders) Configure(cfg config.Config) error {
+	settings, err := mo.Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	mo.Apply(settings)
+	return nil
+}
+
+// Settings is what Configure swaps in for one product. Prepare builds
+// them without changing anything, so a reload can check every product's
+// before applying any.
+type Settings struct {
+	strategy Strategy
+	baseShare float64
+	quoteShare float64
+	timing config.Timing
+	expiry config.Expiry
+}
+
+// Prepare builds our settings from the config.
+func (mo *MyOrders) Prepare(cfg config.Config) (Settings, error) {
+	baseShare, quoteShare := cfg.Allocation(mo.product.Id)
+	cfg.Strategy = cfg.StrategyFor(mo.product.Id)
+	strategy, err := NewStrategy(cfg)
+	if err != nil {
+		return Settings{}, err
 	}
+	return Settings{
+		strategy: strategy,
+		baseShare: baseShare,
+		quoteShare: quoteShare,
+		timing: cfg.Timing,
+		expiry: cfg.Expiry,
+	}, nil
+}
+
+// Apply swaps in settings from Prepare.
+func (mo *MyOrders) Apply(s Settings) {
+	mo.Lock()
+	defer mo.Unlock()
+	mo.strategy = s.strategy
+	mo.baseShare, mo.quoteShare = s.baseShare, s.quoteShare
+	mo.expiry = s.expiry
+	if s.timing != mo.timing {
+		mo.timing = s.timing
+		select {
+			case <- mo.retime:
+			default:
+		}
+		mo.retime <- s.timing
+	}
+}
+
+// SetStore records our orders in s from now on.
+func (mo *MyOrders) SetStore(s *Store) {
+	mo.Lock()
+	defer mo.Unlock()
+	mo.store = s
+}
+
+// SetFee has us hold the fee at rate fee on our buys, as the exchange
+// does. It must be set before we place any orders.
+func (mo *MyOrders) SetFee(fee float64) {
+	mo.Lock()
+	defer mo.Unlock()
+	mo.fee = fee
+}
+
+// SetLedger records our fills in l as we hear of them.
+func (mo *MyOrders) SetLedger(l *Ledger) {
+	mo.Lock()
+	defer mo.Unlock()
+	mo.ledger = l
+}
+
+// SetRisk checks every order we place against r from now on.
+func (mo *MyOrders) SetRisk(r *RiskManager) {
+	mo.Lock()
+	defer mo.Unlock()
+	mo.risk = r
 }
 
 func (mo *MyOrders) StartTicking() {
-	accountTick := time.NewTicker(time.Second * 3).C
-	ordersTick := time.NewTicker(time.Second * 60).C
-	printTick := time.NewTicker(time.Second * 3).C
+	mo.RLock()
+	timing := mo.timing
+	mo.RUnlock()
+	for {
+		timing = mo.tick(timing)
+	}
+}
+
+// tick does the periodic work on the given timing until it changes, and
+// returns the new timing.
+func (mo *MyOrders) tick(timing config.Timing) config.Timing {
+	accountTicker := time.NewTicker(time.Second * time.Duration(timing.AccountSeconds))
+	defer accountTicker.Stop()
+	ordersTicker := time.NewTicker(time.Second * time.Duration(timing.OrdersSeconds))
+	defer ordersTicker.Stop()
+	printTicker := time.NewTicker(time.Second * time.Duration(timing.PrintSeconds))
+	defer printTicker.Stop()
 	//protectTick := time.NewTicker(time.Second * 20).C
-	refillTick := time.NewTicker(time.Second * 10).C
+	refillTicker := time.NewTicker(time.Second * time.Duration(timing.RefillSeconds))
+	defer refillTicker.Stop()
 
 	for {
 		select {
-			case <- accountTick:
+			case <- accountTicker.C:
 				mo.RefreshAccount()
-			case <- ordersTick:
+			case <- ordersTicker.C:
 				mo.RefreshOrders()
 			//case <- protectTick:
-			case <- refillTick:
+			case <- refillTicker.C:
+				mo.ReplaceAging()
 				mo.ProtectBuys()
 				mo.ProtectAsks()
 				mo.RefillBids()
 				mo.RefillAsks()
-			case <- printTick:
+			case <- printTicker.C:
 				log.Printf("%v", mo)
+			case t := <- mo.retime:
+				log.Printf("retiming: %+v", t)
+				return t
 		}
 	}
 }
 
 func (mo *MyOrders) RefreshAccount() {
-	accounts, err := mo.client.GetAccounts()
-	if err != nil {
-		log.Printf("failed to get accounts: %v", err)
-		return
-	}
-	mo.Lock()
-	for _, a := range accounts {
-		if a.Currency == "BTC" {
-			mo.availableBtc = a.Available
-		} else if a.Currency == "USD" {
-			mo.availableUsd = a.Available
-		}
-	}
-	mo.Unlock()
+	mo.account.Refresh(mo.client)
 }
 
+// doneRetention is how long we remember orders after they're done, so
+// that late or repeated news of them is recognized rather than applied
+// twice.
+const doneRetention = time.Minute
+
+// RefreshOrders squares our orders with the ones the exchange lists.
+// Orders it lists that we didn't know about are adopted, ours it no
+// longer lists are dropped, and orders that have been done for a while
+// are forgotten.
 func (mo *MyOrders) RefreshOrders() {
 	log.Printf("refreshing orders")
-	var page []exchange.Order
-	cursor := mo.client.ListOrders()
-
-	orders := make([]exchange.Order, 0)
-	for cursor.HasMore {
-		if err := cursor.NextPage(&page); err != nil {
-			break
-		}
-
-		orders = append(orders, page...)
+	listedAt := time.Now()
+	orders, err := mo.client.ListOrders()
+	if err != nil {
+		log.Printf("failed to list orders: %v", err)
+		return
 	}
 
+	changed := make([]*TrackedOrder, 0)
+	listed := make(map[string]bool)
 	mo.Lock()
-	for id := range mo.myBuys {
-		delete(mo.myBuys, id)
-	}
-	for id := range mo.mySells {
-		delete(mo.mySells, id)
-	}
 	for _, o := range orders {
-		if o.Side == "buy" {
-			mo.myBuys[o.Id] = o
-		} else if o.Side == "sell" {
-			mo.mySells[o.Id] = o
+		if o.ProductId != mo.product.Id {
+			continue
+		}
+		listed[o.Id] = true
+		if _, ok := mo.byId[o.Id]; ok {
+			continue
+		}
+		if t, ok := mo.orders[o.ClientOID]; ok && t.State == OrderPendingNew {
+			t.Acknowledge(o.Id)
+			mo.byId[o.Id] = t
+			changed = append(changed, t)
+			continue
+		}
+		t := adoptOrder(o)
+		if stored, ok := mo.store.Order(o.Id); ok {
+			// pick up where the store left off, under the same client id,
+			// rather than recording the order a second time
+			t.ClientOID = stored.ClientOID
+			if !stored.Created.IsZero() {
+				t.Created = stored.Created
+			}
+			if stored.FilledSize == t.FilledSize {
+				t.FilledValue = stored.FilledValue
+			}
+		}
+		mo.orders[t.ClientOID] = t
+		mo.byId[t.Id] = t
+		log.Printf("adopted order %v", t)
+		changed = append(changed, t)
+	}
+	for key, t := range mo.orders {
+		gone := t.IsLive() && t.State != OrderPendingNew && !listed[t.Id] && t.Updated.Before(listedAt)
+		if gone {
+			log.Printf("order %v is gone", t)
+		}
+		if gone || (!t.IsLive() && time.Since(t.Updated) > doneRetention) {
+			delete(mo.orders, key)
+			delete(mo.byId, t.Id)
 		}
 	}
+	saved := make([]TrackedOrder, len(changed))
+	for i, t := range changed {
+		saved[i] = *t
+	}
 	mo.Unlock()
+	for _, t := range saved {
+		mo.store.SaveOrder(t)
+	}
 }
 
 func (mo *MyOrders) CancelAllOrders() {
-	log.Printf("cancel all my orders")
-	orderIds := make([]string, 0)
-	usd, btc := 0.0, 0.0
+	toCancel := make([]*TrackedOrder, 0)
 	mo.RLock()
-	for id, o := range mo.myBuys {
-		orderIds = append(orderIds, id)
-		usd += o.Price * o.Size
-	}
-	for id, o := range mo.mySells {
-		orderIds = append(orderIds, id)
-		btc += o.Size
+	for _, t := range mo.byId {
+		if t.IsResting() {
+			toCancel = append(toCancel, t)
+		}
 	}
 	mo.RUnlock()
-	mo.updateAvailableUsd(usd)
-	mo.updateAvailableBtc(btc)
-	if len(orderIds) > 0 {
-		var wg sync.WaitGroup
-		wg.Add(len(orderIds))
-		for _, id := range orderIds {
-			go func(wg *sync.WaitGroup, id string) {
-				log.Printf("canceling order %v", id)
-				mo.client.CancelOrder(id)
-				mo.removeBuy(id)
-				mo.removeSell(id)
-				wg.Done()
-			}(&wg, id)
-		}
-		wg.Wait()
+	if len(toCancel) > 0 {
+		log.Printf("canceling all %v of my %v orders", len(toCancel), mo.product)
 	}
+	mo.cancelOrders(toCancel)
 }
 
+// RefillBids places whatever bids the strategy wants that we don't have.
 func (mo *MyOrders) RefillBids() {
-	// starting at this price, increment through 5 cents
-	// check if we have a bid for that amount
-	// if not, place a buy order
-	price := mo.book.BestBidPrice()
-	orders := make([]exchange.Order, 0)
-	size := 0.01 // TODO temp conservative size
-	if size >= 0.01 {
-		current := price
-		for x := 0; mo.totalBuyValue() < mo.currentBtcValue() / 2; x++ {
-			order := exchange.Order{
-				ClientOID: uuid.New(),
-				Price: roundPlus(current, 2),
-				Size: roundPlus(size, 8),
-				Side: "buy",
-				ProductId: "BTC-USD",
-			}
-			if mo.getAvailableUsd() >= order.Price * order.Size {
-				mo.addPendingBuy(order)
-				orders = append(orders, order)
-				mo.updateAvailableUsd(-1 * order.Price * order.Size)
-			} else {
-				break
-			}
-			current -= 0.01 * float64(x % 3)
-		}
-		if len(orders) > 0 {
-			var wg sync.WaitGroup
-			wg.Add(len(orders))
-			for _, o := range orders {
-				go func(wg *sync.WaitGroup, o exchange.Order) {
-					log.Printf("placing bid %0.4f @ %0.2f", o.Size, o.Price)
-					_, err := mo.client.CreateOrder(&o)
-					if err != nil {
-						log.Printf("failed to place bid: %v", err)
-						mo.removePendingBuy(o.ClientOID)
-						mo.updateAvailableUsd(o.Price * o.Size)
-					}
-					wg.Done()
-				}(&wg, o)
-			}
-			wg.Wait()
-		}
-	}
+	mo.refill("buy")
 }
 
 func (mo *MyOrders) HasBuyAtPrice(price float64) bool {
-	mo.RLock()
-	defer mo.RUnlock()
-	for _, o := range mo.myBuys {
-		if roundPlus(price, 2) == roundPlus(o.Price, 2) {
-			return true
-		}
-	}
-	return false
+	return mo.hasOrderAtPrice("buy", price)
 }
 
+// RefillAsks places whatever asks the strategy wants that we don't have.
 func (mo *MyOrders) RefillAsks() {
-	// starting at this price, increment through 5 cents
-	// check if we have an ask for that amount
-	// if not, place a sell order
-	price := mo.book.BestAskPrice()
-	orders := make([]exchange.Order, 0)
-	size := 0.01 // TODO temp conservative size
-	if size >= 0.01 {
-		current := price
-		for x := 0; mo.totalSellValue() < mo.currentBtcValue() / 2; x++ {
-			order := exchange.Order{
-				ClientOID: uuid.New(),
-				Price: roundPlus(current, 2),
-				Size: roundPlus(size, 8),
-				Side: "sell",
-				ProductId: "BTC-USD",
-			}
-			if mo.getAvailableBtc() >= order.Size {
-				mo.addPendingSell(order)
-				orders = append(orders, order)
-				mo.updateAvailableBtc(-1 * order.Size)
-			} else {
-				break
-			}
-			current += 0.01 * float64(x % 3)
-		}
-		if len(orders) > 0 {
-			var wg sync.WaitGroup
-			wg.Add(len(orders))
-			for _, o := range orders {
-				go func(wg *sync.WaitGroup, o exchange.Order) {
-					log.Printf("placing ask %0.4f @ %0.2f (%v)", o.Size, o.Price, o.ClientOID)
-					_, err := mo.client.CreateOrder(&o)
-					if err != nil {
-						log.Printf("failed to place ask: %v", err)
-						mo.removePendingSell(o.ClientOID)
-						mo.updateAvailableBtc(o.Size)
-					}
-					wg.Done()
-				}(&wg, o)
-			}
-			wg.Wait()
-		}
-	}
+	mo.refill("sell")
 }
 
 func (mo *MyOrders) HasSellAtPrice(price float64) bool {
-	mo.RLock()
-	mo.RUnlock()
-	for _, o := range mo.mySells {
-		if roundPlus(price, 2) == roundPlus(o.Price, 2) {
-			return true
-		}
-	}
-	return false
+	return mo.hasOrderAtPrice("sell", price)
 }
 
+// ProtectBuys cancels our bids that the strategy no longer wants.
+// Pending bids are matched first, since they can't be canceled yet.
 func (mo *MyOrders) ProtectBuys() {
-	// starting at the best bid
-	// make sure we don't have any bids more than 5 cents away
-	ordersToCancel := make([]exchange.Order, 0)
-	bestBid := mo.book.BestBidPrice()
+	mo.protect("buy")
+}
+
+// ProtectAsks cancels our asks that the strategy no longer wants.
+// Pending asks are matched first, since they can't be canceled yet.
+func (mo *MyOrders) ProtectAsks() {
+	mo.protect("sell")
+}
+
+// refill places the quotes the strategy wants on side that we don't
+// have, as far as the risk limits and our funds allow. While quoting is
+// halted it places nothing, and cancels our orders if the halt says to,
+// even while the book is resyncing.
+func (mo *MyOrders) refill(side string) {
 	mo.RLock()
-	if mo.myBuys != nil {
-		for _, o := range mo.myBuys {
-			if o.Price < bestBid - 0.04 {
-				log.Printf("canceling bid %v because %0.2f is too low", o.Id, o.Price)
-				ordersToCancel = append(ordersToCancel, o)
-			}
+	risk, expiry := mo.risk, mo.expiry
+	mo.RUnlock()
+	if halted, cancel := risk.Breached(); halted {
+		if cancel {
+			mo.CancelAllOrders()
 		}
+		return
 	}
-	mo.RUnlock()
-	if len(ordersToCancel) > 0 {
+	if !mo.book.IsSynced() {
+		return
+	}
+	existing := mo.working(side)
+	missing, _ := matchQuotes(mo.quotes(side), existing)
+	mid := 0.0
+	if mo.book.BestBidPrice() > 0 && mo.book.BestAskPrice() > 0 {
+		mid = mo.book.Mid()
+	}
+	orders := make([]*TrackedOrder, 0)
+	for _, q := range missing {
+		t := NewTrackedOrder(exchange.Order{
+			ClientOID: uuid.New(),
+			Price: mo.product.RoundPrice(q.Price),
+			Size: roundPlus(q.Size, 8),
+			Side: side,
+			ProductId: mo.product.Id,
+		})
+		if err := risk.Allow(t.Order(), mid, existing); err != nil {
+			log.Printf("risk refused %v %v @ %v: %v", quoteName(side), t.Size, t.Price, err)
+			continue
+		}
+		if !mo.account.Reserve(t.Held(mo.product, mo.fee)) {
+			break
+		}
+		existing = append(existing, t.Order())
+		mo.Lock()
+		mo.orders[t.ClientOID] = t
+		saved := *t
+		mo.Unlock()
+		mo.store.SaveOrder(saved)
+		orders = append(orders, t)
+	}
+	if len(orders) > 0 {
 		var wg sync.WaitGroup
-		wg.Add(len(ordersToCancel))
-		for _, o := range ordersToCancel {
-			go func(wg *sync.WaitGroup, o exchange.Order) {
-				mo.removeBuy(o.Id)
-				mo.updateAvailableUsd(o.Price * o.Size)
-				mo.client.CancelOrder(o.Id)
+		wg.Add(len(orders))
+		for _, t := range orders {
+			go func(wg *sync.WaitGroup, t *TrackedOrder) {
+				o := exchange.Order{
+					ClientOID: t.ClientOID,
+					Price: t.Price,
+					Size: t.Size,
+					Side: t.Side,
+					ProductId: t.ProductId,
+				}
+				if expiry.CancelAfter != "" {
+					o.TimeInForce = "GTT"
+					o.CancelAfter = expiry.CancelAfter
+				}
+				log.Printf("placing %v %0.4f @ %v (%v)", quoteName(side), o.Size, o.Price, o.ClientOID)
+				created, err := mo.client.CreateOrder(&o)
+				if err != nil {
+					log.Printf("failed to place %v: %v", quoteName(side), err)
+					if mo.change(t, t.Reject) {
+						mo.release(t)
+					}
+				} else {
+					mo.acknowledge(t, created.Id)
+				}
 				wg.Done()
-			}(&wg, o)
+			}(&wg, t)
 		}
 		wg.Wait()
 	}
 }
 
-func (mo *MyOrders) ProtectAsks() {
-	// starting at the best ask
-	// make sure we don't have any asks more than 5 cents away
-	ordersToCancel := make([]exchange.Order, 0)
-	bestAsk := mo.book.BestAskPrice()
+// ReplaceAging cancels our resting orders that have been up for longer
+// than the refresh age, so the refill that follows replaces them before
+// the exchange expires them. While we can't quote they're left to
+// expire instead, since nothing would replace them.
+func (mo *MyOrders) ReplaceAging() {
 	mo.RLock()
-	if mo.mySells != nil {
-		for _, o := range mo.mySells {
-			if o.Price > bestAsk + 0.04 {
-				log.Printf("canceling ask %v because %0.2f is too high", o.Id, o.Price)
-				ordersToCancel = append(ordersToCancel, o)
-			}
+	expiry, risk := mo.expiry, mo.risk
+	mo.RUnlock()
+	if expiry.CancelAfter == "" || !mo.book.IsSynced() || risk.Halted() != "" {
+		return
+	}
+	age := time.Duration(expiry.RefreshSeconds) * time.Second
+	toCancel := make([]*TrackedOrder, 0)
+	mo.RLock()
+	for _, t := range mo.byId {
+		if t.IsResting() && time.Since(t.Created) > age {
+			toCancel = append(toCancel, t)
 		}
 	}
 	mo.RUnlock()
-	if len(ordersToCancel) > 0 {
-		var wg sync.WaitGroup
-		wg.Add(len(ordersToCancel))
-		for _, o := range ordersToCancel {
-			go func(wg *sync.WaitGroup, o exchange.Order) {
-				mo.removeSell(o.Id)
-				mo.updateAvailableBtc(o.Size)
-				mo.client.CancelOrder(o.Id)
-				wg.Done()
-			}(&wg, o)
-		}
-		wg.Wait()
+	if len(toCancel) > 0 {
+		log.Printf("replacing %v of my %v orders before they expire", len(toCancel), mo.product)
 	}
+	mo.cancelOrders(toCancel)
 }
 
-func (mo *MyOrders) ReconcilePendingOrder(o *Order) {
-	mo.RLock()
-	buy, buyOk := mo.pendingBuys[o.ClientOID]
-	sell, sellOk := mo.pendingSells[o.ClientOID]
-	mo.RUnlock()
-	if buyOk {
-		mo.Lock()
-		buy.Id = o.Id
-		mo.myBuys[o.Id] = buy
-		delete(mo.pendingBuys, o.ClientOID)
-		mo.Unlock()
+func quoteName(side string) string {
+	if side == "buy" {
+		return "bid"
 	}
-	if sellOk {
-		mo.Lock()
-		sell.Id = o.Id
-		mo.mySells[o.Id] = sell
-		delete(mo.pendingSells, o.ClientOID)
-		mo.Unlock()
+	return "ask"
+}
+
+func (mo *MyOrders) hasOrderAtPrice(side string, price float64) bool {
+	mo.RLock()
+	defer mo.RUnlock()
+	for _, t := range mo.byId {
+		if t.Side == side && t.IsResting() && mo.product.RoundPrice(price) == mo.product.RoundPrice(t.Price) {
+			return true
+		}
 	}
+	return false
 }
 
-func (mo *MyOrders) ReconcileCanceledOrder(o *Order) {
-	usd, btc := 0.0, 0.0
-	mo.Lock()
-	if _, ok := mo.myBuys[o.Id]; ok {
-		delete(mo.myBuys, o.Id)
-		usd += o.Size * o.Price
+func (mo *MyOrders) protect(side string) {
+	if !mo.book.IsSynced() {
+		return
 	}
-	if _, ok := mo.mySells[o.Id]; ok {
-		delete(mo.mySells, o.Id)
-		btc += o.Size
+	_, extra := matchQuotes(mo.quotes(side), mo.working(side))
+	toCancel := make([]*TrackedOrder, 0)
+	mo.RLock()
+	for _, o := range extra {
+		if t, ok := mo.byId[o.Id]; ok && o.Id != "" {
+			log.Printf("canceling %v %v at %v", quoteName(side), o.Id, o.Price)
+			toCancel = append(toCancel, t)
+		}
 	}
-	mo.Unlock()
-	mo.updateAvailableUsd(usd)
-	mo.updateAvailableBtc(btc)
+	mo.RUnlock()
+	mo.cancelOrders(toCancel)
 }
 
-func (mo *MyOrders) ReconcileOrder(o *Order) (buy bool, sell bool) {
-	buy = mo.reconcileBuys(o)
-	sell = mo.reconcileSells(o)
-	return
+// cancelOrders asks the exchange to cancel each order. Their funds are
+// released once a cancel is confirmed, by whichever of the exchange's
+// reply and the feed's done message comes first.
+func (mo *MyOrders) cancelOrders(orders []*TrackedOrder) {
+	if len(orders) > 0 {
+		var wg sync.WaitGroup
+		wg.Add(len(orders))
+		for _, t := range orders {
+			go func(wg *sync.WaitGroup, t *TrackedOrder) {
+				defer wg.Done()
+				if !mo.change(t, t.RequestCancel) {
+					return
+				}
+				log.Printf("canceling order %v", t.Id)
+				if err := mo.client.CancelOrder(t.Id); err != nil {
+					log.Printf("failed to cancel order %v: %v", t.Id, err)
+					mo.change(t, func() error {
+						if t.State != OrderPendingCancel {
+							return errAlready
+						}
+						return t.CancelFailed()
+					})
+					return
+				}
+				mo.canceled(t)
+			}(&wg, t)
+		}
+		wg.Wait()
+	}
 }
 
-func (mo *MyOrders) reconcileBuys(o *Order) bool {
+// working returns our orders on side that are placed or being placed and
+// that we aren't canceling, pending ones first.
+func (mo *MyOrders) working(side string) []exchange.Order {
 	mo.RLock()
-	buy, ok := mo.myBuys[o.Id]
-	mo.RUnlock()
-	if ok {
-		log.Printf("WE BOUGHT ONE (%0.2f)", o.Size)
-	}
-	if ok && o.Size <= 0.0 {
-		log.Printf("adding the btc")
-		mo.updateAvailableBtc(buy.Size)
-		mo.removeBuy(o.Id)
-		return true
-	} else {
-		return false
+	defer mo.RUnlock()
+	orders := make([]*TrackedOrder, 0)
+	for _, t := range mo.orders {
+		if t.Side == side && (t.State == OrderPendingNew || t.IsResting()) {
+			orders = append(orders, t)
+		}
 	}
+	return sortedOrders(orders)
 }
 
-func (mo *MyOrders) reconcileSells(o *Order) bool {
+// quotes asks the strategy for the quotes it wants on one side.
+func (mo *MyOrders) quotes(side string) []Quote {
+	buys, sells := mo.working("buy"), mo.working("sell")
+	heldBase, heldQuote := 0.0, 0.0
+	for _, o := range sells {
+		heldBase += o.Size
+	}
+	for _, o := range buys {
+		heldQuote += o.Price * o.Size
+	}
 	mo.RLock()
-	sell, ok := mo.mySells[o.Id]
+	state := StrategyState{
+		Book: mo.book,
+		BestBid: mo.book.BestBidPrice(),
+		BestAsk: mo.book.BestAskPrice(),
+		Tick: mo.product.Tick,
+		AvailableBase: mo.budget(mo.product.Base, mo.baseShare, heldBase),
+		AvailableQuote: mo.budget(mo.product.Quote, mo.quoteShare, heldQuote),
+		Buys: buys,
+		Sells: sells,
+		Stats: mo.book.TradeStats(),
+	}
+	strategy := mo.strategy
 	mo.RUnlock()
-	if ok {
-		log.Printf("WE SOLD ONE (%0.2f)", o.Size)
-	}
-	if ok && o.Size <= 0.0 {
-		log.Printf("adding the usd")
-		mo.updateAvailableUsd(sell.Size * sell.Price)
-		mo.removeSell(o.Id)
-		return true
-	} else {
-		return false
+	quotes := make([]Quote, 0)
+	for _, q := range strategy.Quotes(state) {
+		if q.Side == side {
+			quotes = append(quotes, q)
+		}
 	}
+	return quotes
 }
 
-func (mo *MyOrders) numBuys() int {
-	mo.RLock()
-	defer mo.RUnlock()
-	return len(mo.myBuys) + len(mo.pendingBuys)
+// budget is how much more of currency the strategy may put into orders:
+// what's available, up to our share of the balance less what our orders
+// already hold, so products sharing a currency can't each quote all of
+// it.
+func (mo *MyOrders) budget(currency string, share float64, held float64) float64 {
+	available := mo.account.Available(currency)
+	if share >= 1 {
+		return available
+	}
+	return math.Max(0, math.Min(available, share * mo.account.Balance(currency) - held))
 }
 
-func (mo *MyOrders) addPendingBuy(o exchange.Order) {
-	mo.Lock()
-	mo.pendingBuys[o.ClientOID] = o
-	mo.Unlock()
-}
+// errAlready is returned by an order change that has already been made,
+// such as a cancel we heard about from both the exchange and the feed.
+var errAlready = errors.New("order change already made")
 
-func (mo *MyOrders) removePendingBuy(clientOID string) {
+// change applies fn to one of our orders under the lock and records the
+// result. A change the order's state doesn't allow is logged and leaves
+// the order, and so our balances, as they were.
+func (mo *MyOrders) change(t *TrackedOrder, fn func() error) bool {
 	mo.Lock()
-	delete(mo.pendingBuys, clientOID)
+	err := fn()
+	if err == nil && t.Id != "" {
+		mo.byId[t.Id] = t
+	}
+	saved := *t
 	mo.Unlock()
+	if err != nil {
+		if err != errAlready {
+			log.Printf("rejected order change: %v", err)
+		}
+		return false
+	}
+	mo.store.SaveOrder(saved)
+	return true
 }
 
-func (mo *MyOrders) numSells() int {
-	mo.RLock()
-	defer mo.RUnlock()
-	return len(mo.mySells) + len(mo.pendingSells)
+// release gives back the funds held for what's left of an order.
+func (mo *MyOrders) release(t *TrackedOrder) {
+	mo.account.Add(t.Held(mo.product, mo.fee))
 }
 
-func (mo *MyOrders) addPendingSell(o exchange.Order) {
-	mo.Lock()
-	mo.pendingSells[o.ClientOID] = o
-	mo.Unlock()
+func (mo *MyOrders) acknowledge(t *TrackedOrder, id string) {
+	mo.change(t, func() error {
+		if t.State != OrderPendingNew && t.Id == id {
+			return errAlready
+		}
+		return t.Acknowledge(id)
+	})
 }
 
-func (mo *MyOrders) removePendingSell(clientOID string) {
-	mo.Lock()
-	delete(mo.pendingSells, clientOID)
-	mo.Unlock()
+func (mo *MyOrders) canceled(t *TrackedOrder) {
+	ok := mo.change(t, func() error {
+		if t.State == OrderCanceled {
+			return errAlready
+		}
+		return t.Cancel()
+	})
+	if ok {
+		mo.release(t)
+	}
 }
 
-func (mo *MyOrders) removeBuy(id string) {
-	mo.Lock()
-	delete(mo.myBuys, id)
-	mo.Unlock()
+// catchUp records fills of t we didn't hear about, given the size the
+// exchange says is left, and returns their size. Our orders only ever
+// rest, so they fill at their own price.
+func catchUp(t *TrackedOrder, remaining float64) (float64, error) {
+	missed := roundPlus(t.Remaining() - math.Max(remaining, 0), 8)
+	if missed <= 0 {
+		return 0, nil
+	}
+	return missed, t.Fill(missed, t.Price)
 }
 
-func (mo *MyOrders) removeSell(id string) {
-	mo.Lock()
-	delete(mo.mySells, id)
-	mo.Unlock()
+// settle moves a fill of t into our balances: what we bought, or the
+// proceeds of what we sold, less the fee. A buy filled below its price
+// also gets back the part of its hold it didn't need; its hold covered
+// the fee at our rate, which is what it's charged when the fill doesn't
+// say.
+func (mo *MyOrders) settle(t *TrackedOrder, size float64, price float64, fee float64) {
+	if t.Side == "buy" {
+		if fee == 0 {
+			fee = size * price * mo.fee
+		}
+		mo.updateAvailableBase(size)
+		mo.updateAvailableQuote(size * t.Price * (1 + mo.fee) - size * price - fee)
+	} else {
+		mo.updateAvailableQuote(size * price - fee)
+	}
 }
 
-func (mo *MyOrders) getAvailableBtc() float64 {
+func (mo *MyOrders) lookup(id string) (*TrackedOrder, bool) {
 	mo.RLock()
 	defer mo.RUnlock()
-	return mo.availableBtc
+	t, ok := mo.byId[id]
+	return t, ok
 }
 
-func (mo *MyOrders) getAvailableUsd() float64 {
+func (mo *MyOrders) ReconcilePendingOrder(o *Order) {
 	mo.RLock()
-	defer mo.RUnlock()
-	return mo.availableUsd
+	t, ok := mo.orders[o.ClientOID]
+	mo.RUnlock()
+	if ok {
+		mo.acknowledge(t, o.Id)
+	}
 }
 
-func (mo *MyOrders) updateAvailableBtc(amount float64) {
-	mo.Lock()
-	defer mo.Unlock()
-	mo.availableBtc += amount
+func (mo *MyOrders) ReconcileCanceledOrder(o *Order) {
+	t, ok := mo.lookup(o.Id)
+	if !ok {
+		return
+	}
+	missed := 0.0
+	ok = mo.change(t, func() error {
+		if t.State == OrderCanceled {
+			return errAlready
+		}
+		var err error
+		if missed, err = catchUp(t, o.Size); err != nil {
+			return err
+		}
+		return t.Cancel()
+	})
+	if ok {
+		mo.settle(t, missed, t.Price, 0)
+		mo.release(t)
+	}
 }
 
-func (mo *MyOrders) updateAvailableUsd(amount float64) {
-	mo.Lock()
-	defer mo.Unlock()
-	mo.availableUsd += amount
+// ReconcileChangedOrder picks up a resize of one of our resting orders,
+// releasing the funds that were held for the difference. A change from a
+// size other than what we think is left means we've missed something, so
+// it's left for the next refresh of our orders to sort out.
+func (mo *MyOrders) ReconcileChangedOrder(o *Order, oldSize float64) {
+	t, ok := mo.lookup(o.Id)
+	if !ok {
+		return
+	}
+	currency, before := "", 0.0
+	ok = mo.change(t, func() error {
+		if roundPlus(oldSize, 8) != t.Remaining() {
+			return fmt.Errorf("order %v changed from %v but has %v left", t.key(), oldSize, t.Remaining())
+		}
+		currency, before = t.Held(mo.product, mo.fee)
+		return t.Resize(o.Size)
+	})
+	if ok {
+		_, after := t.Held(mo.product, mo.fee)
+		mo.account.Add(currency, before - after)
+	}
+}
+
+// FillsFor returns the fills a match gave our orders, one for each side
+// of it that's ours. An order we haven't had the id of yet is found by
+// the client id its received message put on the book, and acknowledged.
+// The feed doesn't say what fee was charged, so that's left for the
+// exchange's own record of the fill.
+func (mo *MyOrders) FillsFor(msg Message) []Fill {
+	fills := make([]Fill, 0)
+	for _, id := range []string{msg.MakerOrderId, msg.TakerOrderId} {
+		if id == "" {
+			continue
+		}
+		t, ok := mo.lookup(id)
+		if !ok {
+			if t, ok = mo.received(id); ok {
+				mo.acknowledge(t, id)
+			}
+		}
+		if ok {
+			fills = append(fills, Fill{
+				Time: msg.ParsedTime(),
+				ProductId: mo.product.Id,
+				TradeId: msg.TradeId,
+				OrderId: id,
+				Side: t.Side,
+				Price: msg.ParsedPrice(),
+				Size: msg.ParsedSize(),
+			})
+		}
+	}
+	return fills
 }
 
-func (mo *MyOrders) totalBuyValue() float64 {
+// received finds our order that the book has under the exchange's id,
+// by the client id on its received message.
+func (mo *MyOrders) received(id string) (*TrackedOrder, bool) {
+	if mo.book == nil {
+		return nil, false
+	}
+	o, ok := mo.book.GetOrder(id)
+	if !ok || o.ClientOID == "" {
+		return nil, false
+	}
 	mo.RLock()
 	defer mo.RUnlock()
-	totalBuy := 0.0
-	for _, o := range mo.myBuys {
-		totalBuy += o.Size
-	}
-	for _, o := range mo.pendingBuys {
-		totalBuy += o.Size
+	t, ok := mo.orders[o.ClientOID]
+	return t, ok
+}
+
+// HandleUserMessage applies a message from the authenticated user feed,
+// which only carries our own orders' messages, so unlike the public feed
+// it needs no book to make sense of them. Anything we already heard from
+// the public feed or the exchange's replies is left alone.
+func (mo *MyOrders) HandleUserMessage(msg Message) {
+	if msg.IsReceived() {
+		mo.ReconcilePendingOrder(msg.Order())
+	} else if msg.IsDone() {
+		o := &Order{Id: msg.OrderId, Price: msg.ParsedPrice(), Size: msg.ParsedRemainingSize()}
+		if msg.IsCanceled() {
+			mo.ReconcileCanceledOrder(o)
+		} else {
+			mo.ReconcileOrder(o)
+		}
+	} else if msg.IsChange() {
+		mo.ReconcileChangedOrder(&Order{Id: msg.OrderId, Price: msg.ParsedPrice(), Size: msg.ParsedNewSize()}, msg.ParsedOldSize())
+	} else if msg.IsMatch() {
+		for _, f := range mo.FillsFor(msg) {
+			mo.ReconcileFill(f)
+		}
 	}
-	return totalBuy
 }
 
-func (mo *MyOrders) totalSellValue() float64 {
+// ReconcileFill applies a fill of one of our orders as soon as we hear of
+// it, to the order, our balances and the ledger. A fill we already have
+// is ignored.
+func (mo *MyOrders) ReconcileFill(f Fill) {
+	t, ok := mo.lookup(f.OrderId)
+	if !ok {
+		return
+	}
 	mo.RLock()
-	defer mo.RUnlock()
-	totalSell := 0.0
-	for _, o := range mo.mySells {
-		totalSell += o.Size
+	ledger, store := mo.ledger, mo.store
+	mo.RUnlock()
+	if ledger != nil && !ledger.Record(f) {
+		return
 	}
-	for _, o := range mo.pendingSells {
-		totalSell += o.Size
+	store.RecordFills([]Fill{f})
+	if mo.change(t, func() error { return t.Fill(f.Size, f.Price) }) {
+		mo.settle(t, f.Size, f.Price, f.Fee)
 	}
-	return totalSell
 }
 
-func (mo *MyOrders) currentBtcValue() float64 {
-	mo.RLock()
-	defer mo.RUnlock()
-	return mo.totalBuyValue() + mo.totalSellValue() + mo.availableBtc + (mo.availableUsd / mo.book.BestBidPrice())
+// ReconcileOrder picks up one of our orders being done or filled, with
+// o.Size what the exchange says is left of it. Fills we missed along the
+// way are settled at the order's price.
+func (mo *MyOrders) ReconcileOrder(o *Order) (buy bool, sell bool) {
+	t, ok := mo.lookup(o.Id)
+	if !ok {
+		return false, false
+	}
+	if t.Side == "buy" {
+		log.Printf("WE BOUGHT ONE (%0.2f)", o.Size)
+	} else {
+		log.Printf("WE SOLD ONE (%0.2f)", o.Size)
+	}
+	missed, filled := 0.0, false
+	ok = mo.change(t, func() error {
+		if t.State == OrderFilled {
+			return errAlready
+		}
+		var err error
+		missed, err = catchUp(t, o.Size)
+		filled = t.State == OrderFilled
+		return err
+	})
+	if !ok {
+		return false, false
+	}
+	mo.settle(t, missed, t.Price, 0)
+	return filled && t.Side == "buy", filled && t.Side == "sell"
+}
+
+func (mo *MyOrders) updateAvailableBase(amount float64) {
+	mo.account.Add(mo.product.Base, amount)
+}
+
+func (mo *MyOrders) updateAvailableQuote(amount float64) {
+	mo.account.Add(mo.product.Quote, amount)
+}
+
+// totals counts our orders on side that are resting and pending, and
+// adds up what's left of them. The caller must hold the lock.
+func (mo *MyOrders) totals(side string) (resting int, pending int, size float64) {
+	for _, t := range mo.orders {
+		if t.Side != side || !t.IsLive() {
+			continue
+		}
+		if t.State == OrderPendingNew {
+			pending++
+		} else {
+			resting++
+		}
+		size += t.Remaining()
+	}
+	return
+}
+
+// currentBaseValue is what the account is worth in the base currency.
+// The caller must hold the lock.
+func (mo *MyOrders) currentBaseValue() float64 {
+	_, _, totalBuy := mo.totals("buy")
+	_, _, totalSell := mo.totals("sell")
+	return totalBuy + totalSell + mo.account.Available(mo.product.Base) + (mo.account.Available(mo.product.Quote) / mo.book.BestBidPrice())
 }
 
-func (mo *MyOrders) currentUsdValue() float64 {
+func (mo *MyOrders) currentQuoteValue() float64 {
 	bestBid := mo.book.BestBidPrice()
-	return mo.currentBtcValue() * bestBid
+	return mo.currentBaseValue() * bestBid
 }
 
 func (mo *MyOrders) String() string {
 	mo.RLock()
 	defer mo.RUnlock()
-	totalBuy, totalSell := 0.0, 0.0
-	for _, o := range mo.myBuys {
-		totalBuy += o.Size
-	}
-	for _, o := range mo.mySells {
-		totalSell += o.Size
-	}
-	usd := mo.availableUsd
-	btc := mo.availableBtc
+	numBuys, pendingBuys, totalBuy := mo.totals("buy")
+	numSells, pendingSells, totalSell := mo.totals("sell")
+	quote := mo.account.Available(mo.product.Quote)
+	base := mo.account.Available(mo.product.Base)
 	bestBid := mo.book.BestBidPrice()
-	buys := fmt.Sprintf("(%0.2f) ", bestBid)
-	for _, o := range mo.myBuys {
-		buys += fmt.Sprintf("%0.2f,", o.Price)
-	}
+	buys := fmt.Sprintf("(%v) ", bestBid)
 	bestAsk := mo.book.BestAskPrice()
-	sells := fmt.Sprintf("(%0.2f) ", bestAsk)
-	for _, o := range mo.mySells {
-		sells += fmt.Sprintf("%0.2f,", o.Price)
+	sells := fmt.Sprintf("(%v) ", bestAsk)
+	for _, t := range mo.byId {
+		if !t.IsLive() {
+			continue
+		}
+		if t.Side == "buy" {
+			buys += fmt.Sprintf("%v,", t.Price)
+		} else {
+			sells += fmt.Sprintf("%v,", t.Price)
+		}
 	}
-	currentValueBtc := mo.currentBtcValue()
-	currentValueUsd := mo.currentUsdValue()
-	return fmt.Sprintf("buys: %v/%v, %0.4f, sells: %v/%v, %0.4f, USD: %0.4f, BTC: %0.4f\ncurrent account value: $%0.2f, %0.8fBTC\nbuys:  %v\nsells: %v", len(mo.myBuys), len(mo.pendingBuys), totalBuy, len(mo.mySells), len(mo.pendingSells), totalSell, usd, btc, currentValueUsd, currentValueBtc, buys, sells)
+	currentValueBase := mo.currentBaseValue()
+	currentValueQuote := mo.currentQuoteValue()
+	p := mo.product
+	return fmt.Sprintf("%v buys: %v/%v, %0.4f, sells: %v/%v, %0.4f, %v: %0.4f, %v: %0.4f\ncurrent account value: %0.2f%v, %0.8f%v\nbuys:  %v\nsells: %v", p.Id, numBuys, pendingBuys, totalBuy, numSells, pendingSells, totalSell, p.Quote, quote, p.Base, base, currentValueQuote, p.Quote, currentValueBase, p.Base, buys, sells)
 }
 
 func round(f float64) float64 {
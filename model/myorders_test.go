@@ -1,9 +1,11 @@
 package model
 
 import (
+	"github.com/sirsean/marketmaker/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"testing"
+	"time"
 	exchange "github.com/preichenberger/go-coinbase-exchange"
 )
 
@@ -13,25 +15,159 @@ type MyOrdersTestSuite struct {
 }
 
 func (s *MyOrdersTestSuite) SetupTest() {
-	s.mo = NewMyOrders(nil, nil)
+	product, _ := ParseProduct("BTC-USD")
+	s.mo = NewMyOrders(nil, nil, product, NewAccount())
+}
+
+// track adds a resting order as though the exchange had acknowledged it.
+func (s *MyOrdersTestSuite) track(id string, side string, price float64, size float64) *TrackedOrder {
+	t := NewTrackedOrder(exchange.Order{ClientOID: "c" + id, Side: side, Price: price, Size: size})
+	assert.NoError(s.T(), t.Acknowledge(id))
+	s.mo.orders[t.ClientOID] = t
+	s.mo.byId[id] = t
+	return t
 }
 
 func (s *MyOrdersTestSuite) TestHasBuy() {
-	s.mo.myBuys["1"] = exchange.Order{Price: 10.1}
-	s.mo.myBuys["2"] = exchange.Order{Price: 10.11}
+	s.track("1", "buy", 10.1, 1.0)
+	s.track("2", "buy", 10.11, 1.0)
 	assert.Equal(s.T(), s.mo.HasBuyAtPrice(10.1), true)
 	assert.Equal(s.T(), s.mo.HasBuyAtPrice(10.2), false)
 	assert.Equal(s.T(), s.mo.HasBuyAtPrice(10.111), true)
 }
 
 func (s *MyOrdersTestSuite) TestHasSell() {
-	s.mo.mySells["1"] = exchange.Order{Price: 10.1}
-	s.mo.mySells["2"] = exchange.Order{Price: 10.11}
+	s.track("1", "sell", 10.1, 1.0)
+	s.track("2", "sell", 10.11, 1.0)
 	assert.Equal(s.T(), s.mo.HasSellAtPrice(10.1), true)
 	assert.Equal(s.T(), s.mo.HasSellAtPrice(10.2), false)
 	assert.Equal(s.T(), s.mo.HasSellAtPrice(10.111), true)
 }
 
+func (s *MyOrdersTestSuite) TestConfigure() {
+	cfg := config.Defaults()
+	cfg.Strategy.Name = "skew"
+	assert.NoError(s.T(), s.mo.Configure(cfg))
+	_, ok := s.mo.strategy.(*SkewStrategy)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), 0, len(s.mo.retime))
+
+	cfg.Timing.RefillSeconds = 30
+	assert.NoError(s.T(), s.mo.Configure(cfg))
+	assert.Equal(s.T(), 30, (<-s.mo.retime).RefillSeconds)
+
+	cfg.Strategy.Name = "martingale"
+	assert.Error(s.T(), s.mo.Configure(cfg))
+	_, ok = s.mo.strategy.(*SkewStrategy)
+	assert.True(s.T(), ok)
+
+	cfg.Strategy.Name = "ladder"
+	settings, err := s.mo.Prepare(cfg)
+	assert.NoError(s.T(), err)
+	_, ok = s.mo.strategy.(*SkewStrategy)
+	assert.True(s.T(), ok)
+	s.mo.Apply(settings)
+	_, ok = s.mo.strategy.(*LadderStrategy)
+	assert.True(s.T(), ok)
+}
+
+func (s *MyOrdersTestSuite) TestReconcileChangedOrder() {
+	buy := s.track("1", "buy", 100.0, 0.5)
+	sell := s.track("2", "sell", 101.0, 0.5)
+	s.mo.ReconcileChangedOrder(&Order{Id: "1", Price: 100.0, Size: 0.2}, 0.5)
+	s.mo.ReconcileChangedOrder(&Order{Id: "2", Price: 101.0, Size: 0.1}, 0.5)
+	s.mo.ReconcileChangedOrder(&Order{Id: "3", Price: 101.0, Size: 0.1}, 0.5)
+	s.mo.ReconcileChangedOrder(&Order{Id: "1", Price: 100.0, Size: 0.1}, 0.5)
+	assert.Equal(s.T(), 0.2, buy.Remaining())
+	assert.Equal(s.T(), 0.1, sell.Remaining())
+	assert.InDelta(s.T(), 30.0, s.mo.account.Available("USD"), 0.000001)
+	assert.InDelta(s.T(), 0.4, s.mo.account.Available("BTC"), 0.000001)
+}
+
+func (s *MyOrdersTestSuite) TestDoneOrdersOnlyCountOnce() {
+	buy := s.track("1", "buy", 100.0, 0.5)
+	sell := s.track("2", "sell", 101.0, 0.5)
+
+	s.mo.ReconcileOrder(&Order{Id: "1", Price: 100.0, Size: 0.2})
+	assert.Equal(s.T(), OrderPartiallyFilled, buy.State)
+	assert.Equal(s.T(), 0.3, buy.FilledSize)
+	assert.InDelta(s.T(), 0.3, s.mo.account.Available("BTC"), 0.000001)
+
+	s.mo.ReconcileOrder(&Order{Id: "1", Price: 100.0, Size: 0.0})
+	s.mo.ReconcileOrder(&Order{Id: "1", Price: 100.0, Size: 0.0})
+	assert.Equal(s.T(), OrderFilled, buy.State)
+	assert.Equal(s.T(), 100.0, buy.AvgPrice())
+	assert.InDelta(s.T(), 0.5, s.mo.account.Available("BTC"), 0.000001)
+
+	s.mo.ReconcileCanceledOrder(&Order{Id: "1", Price: 100.0, Size: 0.5})
+	assert.Equal(s.T(), OrderFilled, buy.State)
+	assert.Equal(s.T(), 0.0, s.mo.account.Available("USD"))
+
+	s.mo.ReconcileCanceledOrder(&Order{Id: "2", Price: 101.0, Size: 0.5})
+	s.mo.ReconcileCanceledOrder(&Order{Id: "2", Price: 101.0, Size: 0.5})
+	s.mo.ReconcileOrder(&Order{Id: "2", Price: 101.0, Size: 0.0})
+	assert.Equal(s.T(), OrderCanceled, sell.State)
+	assert.InDelta(s.T(), 1.0, s.mo.account.Available("BTC"), 0.000001)
+	assert.Equal(s.T(), 0.0, s.mo.account.Available("USD"))
+}
+
+func (s *MyOrdersTestSuite) TestReconcileFill() {
+	ledger := NewLedger(time.Time{})
+	s.mo.SetLedger(ledger)
+	buy := s.track("1", "buy", 100.0, 0.5)
+	sell := s.track("2", "sell", 101.0, 0.5)
+
+	f := Fill{ProductId: "BTC-USD", TradeId: 1, OrderId: "1", Side: "buy", Price: 99.5, Size: 0.2, Fee: 0.1}
+	s.mo.ReconcileFill(f)
+	s.mo.ReconcileFill(f)
+	s.mo.ReconcileFill(Fill{ProductId: "BTC-USD", TradeId: 2, OrderId: "2", Side: "sell", Price: 101.0, Size: 0.5})
+	s.mo.ReconcileFill(Fill{ProductId: "BTC-USD", TradeId: 3, OrderId: "3", Side: "sell", Price: 101.0, Size: 0.5})
+	assert.Equal(s.T(), OrderPartiallyFilled, buy.State)
+	assert.Equal(s.T(), 0.3, buy.Remaining())
+	assert.Equal(s.T(), 99.5, buy.AvgPrice())
+	assert.Equal(s.T(), OrderFilled, sell.State)
+	assert.InDelta(s.T(), 0.2, s.mo.account.Available("BTC"), 0.000001)
+	assert.InDelta(s.T(), 50.5, s.mo.account.Available("USD"), 0.000001)
+
+	// the done message after the last fill has nothing left to settle
+	s.mo.ReconcileOrder(&Order{Id: "2", Price: 101.0, Size: 0.0})
+	assert.InDelta(s.T(), 50.5, s.mo.account.Available("USD"), 0.000001)
+	assert.Equal(s.T(), 2, len(ledger.Fills()))
+	assert.InDelta(s.T(), -0.3, ledger.Session()[0].Position, 0.000001)
+}
+
+func (s *MyOrdersTestSuite) TestFillsForBothSidesAndPendingOrders() {
+	s.mo.book = NewLocalBook(make(chan *Order, 100), make(chan *Order, 100))
+	pending := NewTrackedOrder(exchange.Order{ClientOID: "c9", Side: "buy", Price: 100.0, Size: 0.5})
+	s.mo.orders[pending.ClientOID] = pending
+	s.mo.book.AddOrder(&Order{Id: "9", ClientOID: "c9", Price: 100.0, Size: 0.5})
+	s.track("2", "sell", 100.0, 0.5)
+
+	fills := s.mo.FillsFor(Message{Type: "match", MakerOrderId: "9", TakerOrderId: "2", Price: "100.00", Size: "0.2"})
+	assert.Equal(s.T(), 2, len(fills))
+	assert.Equal(s.T(), "buy", fills[0].Side)
+	assert.Equal(s.T(), "9", fills[0].OrderId)
+	assert.Equal(s.T(), "sell", fills[1].Side)
+	assert.Equal(s.T(), OrderOpen, pending.State)
+	assert.Equal(s.T(), 0, len(s.mo.FillsFor(Message{Type: "match", MakerOrderId: "7", TakerOrderId: "8"})))
+}
+
+func (s *MyOrdersTestSuite) TestHandleUserMessage() {
+	t := NewTrackedOrder(exchange.Order{ClientOID: "c1", Side: "buy", Price: 100.0, Size: 0.5})
+	s.mo.orders[t.ClientOID] = t
+
+	s.mo.HandleUserMessage(Message{Type: "received", OrderId: "1", ClientOID: "c1", Side: "buy", Price: "100.00", Size: "0.5"})
+	assert.Equal(s.T(), OrderOpen, t.State)
+	assert.Equal(s.T(), "1", t.Id)
+	s.mo.HandleUserMessage(Message{Type: "match", TradeId: 7, MakerOrderId: "1", TakerOrderId: "x", Side: "buy", Price: "100.00", Size: "0.2"})
+	s.mo.HandleUserMessage(Message{Type: "change", OrderId: "1", Side: "buy", Price: "100.00", NewSize: "0.2", OldSize: "0.3"})
+	s.mo.HandleUserMessage(Message{Type: "done", OrderId: "1", Side: "buy", Price: "100.00", Reason: "canceled", RemainingSize: "0.2"})
+	assert.Equal(s.T(), OrderCanceled, t.State)
+	assert.Equal(s.T(), 0.2, t.FilledSize)
+	assert.InDelta(s.T(), 0.2, s.mo.account.Available("BTC"), 0.000001)
+	assert.InDelta(s.T(), 30.0, s.mo.account.Available("USD"), 0.000001)
+}
+
 func TestMyOrdersSuite(t *testing.T) {
 	suite.Run(t, new(MyOrdersTestSuite))
 }
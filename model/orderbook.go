@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 )
@@ -28,20 +29,34 @@ func (ob *OrderBook) AskOrders() []*Order {
 	return orders
 }
 
-func DownloadOrderBook() (*OrderBook, error) {
-	resp, err := http.Get("https://api.exchange.coinbase.com/products/BTC-USD/book?level=3")
+func DownloadOrderBook(productId string) (*OrderBook, error) {
+	resp, err := http.Get("https://api.exchange.coinbase.com/products/" + productId + "/book?level=3")
 
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	return readOrderBook(resp)
+}
 
+// readOrderBook decodes a snapshot response, refusing anything that
+// isn't a book, such as a rate limit or server error, rather than
+// loading it as an empty one.
+func readOrderBook(resp *http.Response) (*OrderBook, error) {
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("order book request failed: %v %s", resp.Status, data)
+	}
 
 	ob := OrderBook{}
-	json.Unmarshal(data, &ob)
+	if err := json.Unmarshal(data, &ob); err != nil {
+		return nil, err
+	}
+	if ob.Sequence == 0 {
+		return nil, fmt.Errorf("order book has no sequence: %s", data)
+	}
 	return &ob, nil
 }
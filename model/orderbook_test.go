@@ -0,0 +1,27 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReadOrderBook(t *testing.T) {
+	response := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Body: ioutil.NopCloser(strings.NewReader(body))}
+	}
+
+	ob, err := readOrderBook(response(200, `{"sequence":5,"bids":[["100.00","1.0","b1"]],"asks":[]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), ob.Sequence)
+	assert.Equal(t, 1, len(ob.BidOrders()))
+
+	_, err = readOrderBook(response(429, `{"message":"Slow down"}`))
+	assert.Error(t, err)
+	_, err = readOrderBook(response(200, `<html>`))
+	assert.Error(t, err)
+	_, err = readOrderBook(response(200, `{"bids":[],"asks":[]}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,353 @@
+package model
+
+import (
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"code.google.com/p/go-uuid/uuid"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrderListener is told about our own orders' lifecycle by exchanges that
+// can't report it through the public feed.
+type OrderListener interface {
+	ReconcilePendingOrder(o *Order)
+	ReconcileFill(f Fill)
+	ReconcileOrder(o *Order) (bool, bool)
+	ReconcileCanceledOrder(o *Order)
+}
+
+// PaperExchange simulates an account on the exchange. Orders rest locally
+// and are filled from the match stream of their product's book; nothing
+// is ever sent to the real exchange. Each order tracks an estimate of
+// the size queued ahead of it at its price, and only fills once trades
+// at that price have used it up, or immediately if a trade prints
+// through its price.
+type PaperExchange struct {
+	sync.Mutex
+	products map[string]Product
+	books map[string]*LocalBook
+	fee float64
+	balances map[string]float64
+	holds map[string]float64
+	orders map[string]*exchange.Order
+	ahead map[string]float64
+	fills []Fill
+	listeners map[string]OrderListener
+}
+
+func NewPaperExchange(balances map[string]float64, fee float64) *PaperExchange {
+	p := &PaperExchange{
+		products: make(map[string]Product),
+		books: make(map[string]*LocalBook),
+		fee: fee,
+		balances: make(map[string]float64),
+		holds: make(map[string]float64),
+		orders: make(map[string]*exchange.Order),
+		ahead: make(map[string]float64),
+		fills: make([]Fill, 0),
+		listeners: make(map[string]OrderListener),
+	}
+	for currency, balance := range balances {
+		p.balances[currency] = balance
+		p.holds[currency] = 0
+	}
+	return p
+}
+
+// AddProduct lets orders be placed on product, filled from its book.
+func (p *PaperExchange) AddProduct(product Product, book *LocalBook) {
+	p.Lock()
+	defer p.Unlock()
+	p.products[product.Id] = product
+	p.books[product.Id] = book
+	for _, currency := range []string{product.Base, product.Quote} {
+		if _, ok := p.balances[currency]; !ok {
+			p.balances[currency] = 0
+			p.holds[currency] = 0
+		}
+	}
+}
+
+// SetListener tells l about our orders on productId.
+func (p *PaperExchange) SetListener(productId string, l OrderListener) {
+	p.Lock()
+	defer p.Unlock()
+	p.listeners[productId] = l
+}
+
+func (p *PaperExchange) GetAccounts() ([]exchange.Account, error) {
+	p.Lock()
+	defer p.Unlock()
+	accounts := make([]exchange.Account, 0, len(p.balances))
+	for currency, balance := range p.balances {
+		accounts = append(accounts, exchange.Account{
+			Id: currency,
+			Currency: currency,
+			Balance: balance,
+			Hold: p.holds[currency],
+			Available: balance - p.holds[currency],
+		})
+	}
+	return accounts, nil
+}
+
+func (p *PaperExchange) ListOrders() ([]exchange.Order, error) {
+	p.Lock()
+	defer p.Unlock()
+	orders := make([]exchange.Order, 0, len(p.orders))
+	for _, o := range p.orders {
+		orders = append(orders, *o)
+	}
+	return orders, nil
+}
+
+// CreateOrder places a resting limit order. Orders that would cross the
+// book are rejected, since we have no way to simulate taking liquidity.
+func (p *PaperExchange) CreateOrder(o *exchange.Order) (exchange.Order, error) {
+	if o.Size <= 0 || o.Price <= 0 {
+		return exchange.Order{}, errors.New("invalid order size or price")
+	}
+	p.Lock()
+	product, productOk := p.products[o.ProductId]
+	book := p.books[o.ProductId]
+	p.Unlock()
+	if !productOk {
+		return exchange.Order{}, errors.New("unknown product")
+	}
+	currency, amount := holdFor(product, o, p.fee)
+	if currency == "" {
+		return exchange.Order{}, errors.New("invalid order side")
+	}
+	if o.Side == "buy" && book.BestAskPrice() > 0 && o.Price >= book.BestAskPrice() {
+		return exchange.Order{}, errors.New("order would take liquidity")
+	}
+	if o.Side == "sell" && o.Price <= book.BestBidPrice() {
+		return exchange.Order{}, errors.New("order would take liquidity")
+	}
+
+	p.Lock()
+	if p.balances[currency] - p.holds[currency] < amount {
+		p.Unlock()
+		return exchange.Order{}, errors.New("insufficient funds")
+	}
+	order := *o
+	order.Id = uuid.New()
+	order.Status = "open"
+	p.holds[currency] += amount
+	ahead := book.DepthAt(Side(order.Side), order.Price)
+	for _, other := range p.orders {
+		if other.ProductId == order.ProductId && other.Side == order.Side && other.Price == order.Price {
+			ahead += other.Size - other.FilledSize
+		}
+	}
+	p.orders[order.Id] = &order
+	p.ahead[order.Id] = ahead
+	listener := p.listeners[order.ProductId]
+	p.Unlock()
+
+	if listener != nil {
+		listener.ReconcilePendingOrder(paperOrder(&order))
+	}
+	return order, nil
+}
+
+func (p *PaperExchange) CancelOrder(id string) error {
+	p.Lock()
+	o, ok := p.orders[id]
+	if !ok {
+		p.Unlock()
+		return errors.New("order not found")
+	}
+	currency, amount := holdFor(p.products[o.ProductId], o, p.fee)
+	p.holds[currency] -= amount
+	delete(p.orders, id)
+	delete(p.ahead, id)
+	listener := p.listeners[o.ProductId]
+	p.Unlock()
+
+	if listener != nil {
+		listener.ReconcileCanceledOrder(paperOrder(o))
+	}
+	return nil
+}
+
+// Fills returns every fill so far, oldest first.
+func (p *PaperExchange) Fills() []Fill {
+	p.Lock()
+	defer p.Unlock()
+	fills := make([]Fill, len(p.fills))
+	copy(fills, p.fills)
+	return fills
+}
+
+// RecentFills returns every fill on productId so far.
+func (p *PaperExchange) RecentFills(productId string) ([]Fill, error) {
+	p.Lock()
+	defer p.Unlock()
+	fills := make([]Fill, 0)
+	for _, f := range p.fills {
+		if f.ProductId == productId {
+			fills = append(fills, f)
+		}
+	}
+	return fills, nil
+}
+
+// HandleMatch fills our resting orders on the trade's product. The
+// match's side is the maker's, so a sell match means buyers lifted the
+// offer. Our asks below its price were traded through and fill from the
+// trade's size, best price first; asks at its price only fill with what's
+// left of the trade once the queue ahead of them is used up.
+func (p *PaperExchange) HandleMatch(msg Message) {
+	price := msg.ParsedPrice()
+	size := msg.ParsedSize()
+	remaining := size
+	t := msg.ParsedTime()
+
+	p.Lock()
+	candidates := make([]*exchange.Order, 0)
+	for _, o := range p.orders {
+		if o.ProductId != msg.ProductId {
+			continue
+		}
+		if msg.IsSell() && o.Side == "sell" && o.Price <= price {
+			candidates = append(candidates, o)
+		} else if msg.IsBuy() && o.Side == "buy" && o.Price >= price {
+			candidates = append(candidates, o)
+		}
+	}
+	sort.Sort(paperPriority(candidates))
+	filled := make([]*Order, 0)
+	fills := make([]Fill, 0)
+	for _, o := range candidates {
+		var fill float64
+		if o.Price == price {
+			ahead := p.ahead[o.Id] - remaining
+			p.ahead[o.Id] = math.Max(ahead, 0)
+			fill = math.Min(-ahead, o.Size - o.FilledSize)
+		} else {
+			fill = math.Min(remaining, o.Size - o.FilledSize)
+			remaining -= fill
+		}
+		if fill > 0 {
+			fills = append(fills, p.fill(o, fill, msg.TradeId, t))
+			filled = append(filled, paperOrder(o))
+		}
+	}
+	listener := p.listeners[msg.ProductId]
+	p.Unlock()
+
+	if listener != nil {
+		for i, o := range filled {
+			listener.ReconcileFill(fills[i])
+			listener.ReconcileOrder(o)
+		}
+	}
+}
+
+// HandleCancel moves our orders up the queue when an order is canceled
+// at their price. We can't tell whether it was ahead of us, so the queue
+// shrinks by the chance that it was. Call it before the order is removed
+// from the book.
+func (p *PaperExchange) HandleCancel(msg Message, o *Order) {
+	p.Lock()
+	book, ok := p.books[msg.ProductId]
+	p.Unlock()
+	if !ok {
+		return
+	}
+	depth := book.DepthAt(Side(msg.Side), o.Price)
+	if depth <= 0 {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	for id, mine := range p.orders {
+		if mine.ProductId == msg.ProductId && mine.Side == msg.Side && mine.Price == o.Price {
+			ahead := p.ahead[id]
+			p.ahead[id] = math.Max(ahead - o.Size * math.Min(ahead / depth, 1), 0)
+		}
+	}
+}
+
+// fill moves funds for a fill of size on o at its limit price, and
+// returns the fill. The caller must hold the lock.
+func (p *PaperExchange) fill(o *exchange.Order, size float64, tradeId int64, t time.Time) Fill {
+	product := p.products[o.ProductId]
+	value := size * o.Price
+	fee := value * p.fee
+	if o.Side == "buy" {
+		p.holds[product.Quote] -= value + fee
+		p.balances[product.Quote] -= value + fee
+		p.balances[product.Base] += size
+	} else {
+		p.holds[product.Base] -= size
+		p.balances[product.Base] -= size
+		p.balances[product.Quote] += value - fee
+	}
+	o.FilledSize += size
+	o.FillFees += fee
+	o.ExecutedValue += value
+	f := Fill{
+		Time: t,
+		ProductId: o.ProductId,
+		TradeId: tradeId,
+		OrderId: o.Id,
+		Side: o.Side,
+		Price: o.Price,
+		Size: size,
+		Fee: fee,
+	}
+	p.fills = append(p.fills, f)
+	log.Printf("paper fill: %v %v %0.4f @ %v", o.ProductId, o.Side, size, o.Price)
+	if o.Size - o.FilledSize <= 0 {
+		o.Status = "done"
+		delete(p.orders, o.Id)
+		delete(p.ahead, o.Id)
+	}
+	return f
+}
+
+// holdFor returns the currency and amount held while o is open, which
+// for a buy includes the fee its fills will be charged.
+func holdFor(product Product, o *exchange.Order, fee float64) (string, float64) {
+	remaining := o.Size - o.FilledSize
+	if o.Side == "buy" {
+		return product.Quote, remaining * o.Price * (1 + fee)
+	} else if o.Side == "sell" {
+		return product.Base, remaining
+	} else {
+		return "", 0
+	}
+}
+
+func paperOrder(o *exchange.Order) *Order {
+	return &Order{
+		Id: o.Id,
+		ClientOID: o.ClientOID,
+		Price: o.Price,
+		Size: o.Size - o.FilledSize,
+	}
+}
+
+type paperPriority []*exchange.Order
+
+func (p paperPriority) Len() int {
+	return len(p)
+}
+
+func (p paperPriority) Less(i, j int) bool {
+	if p[i].Side == "buy" {
+		return p[i].Price > p[j].Price
+	} else {
+		return p[i].Price < p[j].Price
+	}
+}
+
+func (p paperPriority) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
@@ -0,0 +1,323 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"path/filepath"
+	"testing"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type PaperExchangeTestSuite struct {
+	suite.Suite
+	paper *PaperExchange
+	mo *MyOrders
+}
+
+func (s *PaperExchangeTestSuite) SetupTest() {
+	book := NewLocalBook(make(chan *Order, 100), make(chan *Order, 100))
+	book.Load(&OrderBook{
+		Sequence: 1,
+		Bids: [][]string{{"100.00", "1.0", "b1"}},
+		Asks: [][]string{{"102.00", "1.0", "a1"}},
+	})
+	product, _ := ParseProduct("BTC-USD")
+	s.paper = NewPaperExchange(map[string]float64{"USD": 1000.0, "BTC": 1.0}, 0.0)
+	s.paper.AddProduct(product, book)
+	s.mo = NewMyOrders(s.paper, book, product, NewAccount())
+	s.paper.SetListener("BTC-USD", s.mo)
+	s.mo.RefreshAccount()
+}
+
+// place tracks o as pending, as a refill would before sending it.
+func (s *PaperExchangeTestSuite) place(o exchange.Order) *TrackedOrder {
+	t := NewTrackedOrder(o)
+	s.mo.orders[t.ClientOID] = t
+	return t
+}
+
+func (s *PaperExchangeTestSuite) available() map[string]float64 {
+	accounts, _ := s.paper.GetAccounts()
+	available := make(map[string]float64)
+	for _, a := range accounts {
+		available[a.Currency] = a.Available
+	}
+	return available
+}
+
+func (s *PaperExchangeTestSuite) TestCreateAndCancel() {
+	o := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "buy", Price: 100.0, Size: 2.0}
+	t := s.place(o)
+	created, err := s.paper.CreateOrder(&o)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 800.0, s.available()["USD"])
+	assert.Equal(s.T(), OrderOpen, t.State)
+	assert.Equal(s.T(), created.Id, t.Id)
+
+	assert.Nil(s.T(), s.paper.CancelOrder(created.Id))
+	assert.Equal(s.T(), 1000.0, s.available()["USD"])
+	assert.Equal(s.T(), OrderCanceled, t.State)
+}
+
+func (s *PaperExchangeTestSuite) TestRejects() {
+	_, err := s.paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "buy", Price: 102.0, Size: 1.0})
+	assert.NotNil(s.T(), err)
+	_, err = s.paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "sell", Price: 101.0, Size: 2.0})
+	assert.NotNil(s.T(), err)
+	_, err = s.paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "buy", Price: 101.0, Size: 20.0})
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PaperExchangeTestSuite) TestFillFromMatches() {
+	o := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "sell", Price: 101.0, Size: 1.0}
+	t := s.place(o)
+	s.mo.updateAvailableBase(-1.0)
+	s.paper.CreateOrder(&o)
+
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "sell", Price: "101.50", Size: "0.4"})
+	assert.Equal(s.T(), OrderPartiallyFilled, t.State)
+	assert.InDelta(s.T(), 0.4, t.FilledSize, 0.000001)
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "sell", Price: "101.00", Size: "0.5"})
+	assert.Equal(s.T(), OrderPartiallyFilled, t.State)
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "sell", Price: "101.00", Size: "5"})
+	assert.Equal(s.T(), OrderFilled, t.State)
+	assert.InDelta(s.T(), 101.0, s.mo.account.Available("USD") - 1000.0, 0.000001)
+	assert.Equal(s.T(), 1101.0, s.available()["USD"])
+	assert.Equal(s.T(), 0.0, s.available()["BTC"])
+}
+
+func (s *PaperExchangeTestSuite) TestQueuePosition() {
+	o := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "buy", Price: 100.0, Size: 1.0}
+	t := s.place(o)
+	s.paper.CreateOrder(&o)
+
+	// b1 is 1.0 ahead of us; trades at our price eat into it first
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "100.00", Size: "0.6"})
+	assert.Equal(s.T(), 0, len(s.paper.Fills()))
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "100.00", Size: "0.6"})
+	assert.Equal(s.T(), 1, len(s.paper.Fills()))
+	assert.InDelta(s.T(), 0.2, s.paper.Fills()[0].Size, 0.000001)
+
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "99.00", Size: "2.0"})
+	assert.Equal(s.T(), 2, len(s.paper.Fills()))
+	assert.InDelta(s.T(), 0.8, s.paper.Fills()[1].Size, 0.000001)
+	assert.Equal(s.T(), OrderFilled, t.State)
+}
+
+func (s *PaperExchangeTestSuite) TestTradeThroughLeavesLessAtPrice() {
+	better := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "buy", Price: 100.5, Size: 1.0}
+	s.place(better)
+	s.paper.CreateOrder(&better)
+	atPrice := exchange.Order{ProductId: "BTC-USD", ClientOID: "c2", Side: "buy", Price: 99.0, Size: 1.0}
+	s.place(atPrice)
+	s.paper.CreateOrder(&atPrice)
+
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "99.00", Size: "1.5"})
+	fills := s.paper.Fills()
+	assert.Equal(s.T(), 2, len(fills))
+	assert.Equal(s.T(), 100.5, fills[0].Price)
+	assert.Equal(s.T(), 1.0, fills[0].Size)
+	assert.Equal(s.T(), 99.0, fills[1].Price)
+	assert.Equal(s.T(), 0.5, fills[1].Size)
+}
+
+func (s *PaperExchangeTestSuite) TestCancelAheadMovesUpQueue() {
+	o := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "buy", Price: 100.0, Size: 1.0}
+	s.place(o)
+	s.paper.CreateOrder(&o)
+
+	b1, _ := s.paper.books["BTC-USD"].GetOrder("b1")
+	s.paper.HandleCancel(Message{ProductId: "BTC-USD", Side: "buy"}, b1)
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "100.00", Size: "0.5"})
+	assert.Equal(s.T(), 1, len(s.paper.Fills()))
+	assert.InDelta(s.T(), 0.5, s.paper.Fills()[0].Size, 0.000001)
+}
+
+func (s *PaperExchangeTestSuite) TestBuysHoldTheFee() {
+	product, _ := ParseProduct("BTC-USD")
+	s.paper = NewPaperExchange(map[string]float64{"USD": 1000.0, "BTC": 1.0}, 0.01)
+	s.paper.AddProduct(product, s.mo.book)
+	_, err := s.paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "buy", Price: 100.0, Size: 10.0})
+	assert.Error(s.T(), err)
+
+	s.mo = NewMyOrders(s.paper, s.mo.book, product, NewAccount())
+	s.mo.SetFee(0.01)
+	s.paper.SetListener("BTC-USD", s.mo)
+	s.mo.RefreshAccount()
+	s.mo.book.SetSynced(true)
+	s.mo.strategy = fixedStrategy{{Side: "buy", Price: 100.0, Size: 2.0}}
+	s.mo.RefillBids()
+	assert.True(s.T(), s.mo.HasBuyAtPrice(100.0))
+	assert.InDelta(s.T(), 798.0, s.available()["USD"], 0.000001)
+	assert.InDelta(s.T(), 798.0, s.mo.account.Available("USD"), 0.000001)
+
+	b1, _ := s.paper.books["BTC-USD"].GetOrder("b1")
+	s.paper.HandleCancel(Message{ProductId: "BTC-USD", Side: "buy"}, b1)
+	s.paper.HandleMatch(Message{ProductId: "BTC-USD", Side: "buy", Price: "100.00", Size: "1.0"})
+	assert.Equal(s.T(), 1, len(s.paper.Fills()))
+	assert.InDelta(s.T(), 798.0, s.available()["USD"], 0.000001)
+	assert.InDelta(s.T(), 798.0, s.mo.account.Available("USD"), 0.000001)
+
+	s.mo.strategy = fixedStrategy{}
+	s.mo.ProtectBuys()
+	assert.InDelta(s.T(), 899.0, s.available()["USD"], 0.000001)
+	assert.InDelta(s.T(), 899.0, s.mo.account.Available("USD"), 0.000001)
+}
+
+func (s *PaperExchangeTestSuite) TestRefreshFindsStoredOrders() {
+	store, err := OpenStore(filepath.Join(s.T().TempDir(), "test.db"))
+	assert.Nil(s.T(), err)
+	defer store.Close()
+	created, err := s.paper.CreateOrder(&exchange.Order{ProductId: "BTC-USD", Side: "sell", Price: 103.0, Size: 0.5})
+	assert.Nil(s.T(), err)
+	t := NewTrackedOrder(exchange.Order{ClientOID: "c1", ProductId: "BTC-USD", Side: "sell", Price: 103.0, Size: 0.5})
+	t.Acknowledge(created.Id)
+	store.SaveOrder(*t)
+
+	s.mo.SetStore(store)
+	s.mo.RefreshOrders()
+	adopted, ok := s.mo.orders["c1"]
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), created.Id, adopted.Id)
+	orders, _ := store.Orders()
+	assert.Equal(s.T(), 1, len(orders))
+}
+
+type fixedStrategy []Quote
+
+func (f fixedStrategy) Quotes(state StrategyState) []Quote {
+	return f
+}
+
+func (s *PaperExchangeTestSuite) TestProductsShareAccount() {
+	book := NewLocalBook(make(chan *Order, 100), make(chan *Order, 100))
+	book.Load(&OrderBook{
+		Sequence: 1,
+		Bids: [][]string{{"0.05000", "10.0", "e1"}},
+		Asks: [][]string{{"0.06000", "10.0", "e2"}},
+	})
+	book.SetSynced(true)
+	product, _ := ParseProduct("ETH-BTC")
+	s.paper.AddProduct(product, book)
+	eth := NewMyOrders(s.paper, book, product, s.mo.account)
+	s.paper.SetListener("ETH-BTC", eth)
+	eth.strategy = fixedStrategy{{Side: "buy", Price: 0.05001, Size: 10.0}}
+	s.paper.books["BTC-USD"].SetSynced(true)
+	s.mo.strategy = fixedStrategy{{Side: "sell", Price: 103.0, Size: 0.7}}
+
+	s.mo.RefillAsks()
+	eth.RefillBids()
+	assert.True(s.T(), s.mo.HasSellAtPrice(103.0))
+	assert.Equal(s.T(), 0, len(eth.working("buy")))
+	assert.InDelta(s.T(), 0.3, s.mo.account.Available("BTC"), 0.000001)
+
+	s.mo.strategy = fixedStrategy{}
+	s.mo.ProtectAsks()
+	eth.RefillBids()
+	assert.True(s.T(), eth.HasBuyAtPrice(0.05001))
+	assert.InDelta(s.T(), 0.4999, s.mo.account.Available("BTC"), 0.000001)
+	assert.InDelta(s.T(), 0.4999, s.available()["BTC"], 0.000001)
+}
+
+// seenStrategy quotes nothing, keeping the last state it was asked about.
+type seenStrategy struct {
+	state StrategyState
+}
+
+func (f *seenStrategy) Quotes(state StrategyState) []Quote {
+	f.state = state
+	return nil
+}
+
+func (s *PaperExchangeTestSuite) TestProductsSplitSharedCurrency() {
+	product, _ := ParseProduct("ETH-BTC")
+	eth := NewMyOrders(s.paper, s.paper.books["BTC-USD"], product, s.mo.account)
+	cfg := config.Defaults()
+	cfg.Product = map[string]*config.Strategy{"BTC-USD": {}, "ETH-BTC": {}}
+	assert.NoError(s.T(), s.mo.Configure(cfg))
+	assert.NoError(s.T(), eth.Configure(cfg))
+	btc, eths := &seenStrategy{}, &seenStrategy{}
+	s.mo.strategy, eth.strategy = btc, eths
+
+	s.mo.quotes("sell")
+	eth.quotes("buy")
+	assert.InDelta(s.T(), 0.5, btc.state.AvailableBase, 0.000001)
+	assert.InDelta(s.T(), 1000.0, btc.state.AvailableQuote, 0.000001)
+	assert.InDelta(s.T(), 0.5, eths.state.AvailableQuote, 0.000001)
+
+	o := exchange.Order{ProductId: "BTC-USD", ClientOID: "c1", Side: "sell", Price: 103.0, Size: 0.2}
+	s.place(o)
+	s.paper.CreateOrder(&o)
+	s.mo.RefreshAccount()
+	s.mo.quotes("sell")
+	eth.quotes("buy")
+	assert.InDelta(s.T(), 0.3, btc.state.AvailableBase, 0.000001)
+	assert.InDelta(s.T(), 0.5, eths.state.AvailableQuote, 0.000001)
+}
+
+func (s *PaperExchangeTestSuite) TestRiskLimitsAndHalt() {
+	risk := NewRiskManager(config.Risk{MaxOrderSize: 0.5}, NewLedger(time.Now()))
+	s.mo.SetRisk(risk)
+	s.paper.books["BTC-USD"].SetSynced(true)
+	s.mo.strategy = fixedStrategy{{Side: "sell", Price: 103.0, Size: 0.7}, {Side: "sell", Price: 104.0, Size: 0.3}}
+
+	s.mo.RefillAsks()
+	assert.False(s.T(), s.mo.HasSellAtPrice(103.0))
+	assert.True(s.T(), s.mo.HasSellAtPrice(104.0))
+
+	s.mo.strategy = fixedStrategy{{Side: "sell", Price: 104.0, Size: 0.3}, {Side: "sell", Price: 105.0, Size: 0.3}}
+	risk.Halt("test", false)
+	s.mo.RefillAsks()
+	assert.True(s.T(), s.mo.HasSellAtPrice(104.0))
+	assert.False(s.T(), s.mo.HasSellAtPrice(105.0))
+
+	risk.Halt("test", true)
+	s.mo.RefillAsks()
+	assert.False(s.T(), s.mo.HasSellAtPrice(104.0))
+	assert.Equal(s.T(), 1.0, s.available()["BTC"])
+}
+
+func (s *PaperExchangeTestSuite) TestHaltCancelsWhileResyncing() {
+	risk := NewRiskManager(config.Risk{}, NewLedger(time.Now()))
+	s.mo.SetRisk(risk)
+	s.paper.books["BTC-USD"].SetSynced(true)
+	s.mo.strategy = fixedStrategy{{Side: "sell", Price: 103.0, Size: 0.5}}
+	s.mo.RefillAsks()
+	assert.True(s.T(), s.mo.HasSellAtPrice(103.0))
+
+	s.paper.books["BTC-USD"].SetSynced(false)
+	risk.Halt("test", true)
+	s.mo.RefillAsks()
+	assert.False(s.T(), s.mo.HasSellAtPrice(103.0))
+	assert.Equal(s.T(), 1.0, s.available()["BTC"])
+}
+
+func (s *PaperExchangeTestSuite) TestOrdersExpireAndAreReplaced() {
+	s.paper.books["BTC-USD"].SetSynced(true)
+	s.mo.strategy = fixedStrategy{{Side: "sell", Price: 103.0, Size: 0.5}}
+	s.mo.RefillAsks()
+	orders, _ := s.paper.ListOrders()
+	assert.Equal(s.T(), 1, len(orders))
+	assert.Equal(s.T(), "GTT", orders[0].TimeInForce)
+	assert.Equal(s.T(), "min", orders[0].CancelAfter)
+
+	s.mo.ReplaceAging()
+	s.mo.RefillAsks()
+	again, _ := s.paper.ListOrders()
+	assert.Equal(s.T(), orders[0].Id, again[0].Id)
+
+	s.mo.byId[orders[0].Id].Created = time.Now().Add(-time.Minute)
+	s.mo.ReplaceAging()
+	s.mo.RefillAsks()
+	again, _ = s.paper.ListOrders()
+	assert.Equal(s.T(), 1, len(again))
+	assert.NotEqual(s.T(), orders[0].Id, again[0].Id)
+	assert.True(s.T(), s.mo.HasSellAtPrice(103.0))
+	assert.InDelta(s.T(), 0.5, s.mo.account.Available("BTC"), 0.000001)
+}
+
+func TestPaperExchangeSuite(t *testing.T) {
+	suite.Run(t, new(PaperExchangeTestSuite))
+}
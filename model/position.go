@@ -0,0 +1,65 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// Fill is a single execution of one of our orders.
+type Fill struct {
+	Time time.Time
+	ProductId string
+	TradeId int64
+	OrderId string
+	Side string
+	Price float64
+	Size float64
+	Fee float64
+}
+
+// Position does average-cost accounting for a stream of fills. Size is
+// positive when long and negative when short; closing any part of it
+// realizes the difference between the fill price and the average cost.
+type Position struct {
+	Size float64
+	AvgCost float64
+	Realized float64
+	Fees float64
+}
+
+func (p *Position) Apply(f Fill) {
+	p.Fees += f.Fee
+	size := f.Size
+	if f.Side == "sell" {
+		size = -size
+	}
+	if p.Size == 0 || math.Signbit(p.Size) == math.Signbit(size) {
+		p.AvgCost = (p.AvgCost * math.Abs(p.Size) + f.Price * math.Abs(size)) / math.Abs(p.Size + size)
+		p.Size += size
+		return
+	}
+	closed := math.Min(math.Abs(size), math.Abs(p.Size))
+	if p.Size > 0 {
+		p.Realized += closed * (f.Price - p.AvgCost)
+	} else {
+		p.Realized += closed * (p.AvgCost - f.Price)
+	}
+	p.Size += size
+	if math.Abs(p.Size) < 1e-12 {
+		p.Size = 0
+		p.AvgCost = 0
+	} else if math.Signbit(p.Size) == math.Signbit(size) {
+		// flipped through flat; what's left was opened at this price
+		p.AvgCost = f.Price
+	}
+}
+
+// Unrealized is the profit from closing the position at mark.
+func (p *Position) Unrealized(mark float64) float64 {
+	return p.Size * (mark - p.AvgCost)
+}
+
+// Pnl is realized plus unrealized profit at mark, net of fees.
+func (p *Position) Pnl(mark float64) float64 {
+	return p.Realized + p.Unrealized(mark) - p.Fees
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPositionAverageCost(t *testing.T) {
+	p := Position{}
+	p.Apply(Fill{Side: "buy", Price: 100.0, Size: 1.0})
+	p.Apply(Fill{Side: "buy", Price: 110.0, Size: 1.0, Fee: 0.5})
+	assert.Equal(t, 2.0, p.Size)
+	assert.Equal(t, 105.0, p.AvgCost)
+	assert.Equal(t, 10.0, p.Unrealized(110.0))
+
+	p.Apply(Fill{Side: "sell", Price: 120.0, Size: 0.5})
+	assert.Equal(t, 1.5, p.Size)
+	assert.Equal(t, 7.5, p.Realized)
+	assert.Equal(t, 105.0, p.AvgCost)
+
+	p.Apply(Fill{Side: "sell", Price: 100.0, Size: 2.5})
+	assert.Equal(t, -1.0, p.Size)
+	assert.Equal(t, 100.0, p.AvgCost)
+	assert.Equal(t, 0.0, p.Realized)
+	assert.Equal(t, -5.5, p.Pnl(105.0))
+}
@@ -0,0 +1,61 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Product is a market on the exchange. BTC-USD trades the base currency,
+// BTC, for the quote currency, USD.
+type Product struct {
+	Id string
+	Base string
+	Quote string
+	// Tick is the smallest price increment, in the quote currency.
+	Tick float64
+}
+
+// ParseProduct splits a product id into its currencies. Products quoted
+// in fiat tick in cents; anything quoted in crypto ticks in 0.00001.
+func ParseProduct(id string) (Product, error) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Product{}, fmt.Errorf("invalid product: %v", id)
+	}
+	tick := 0.00001
+	switch parts[1] {
+	case "USD", "EUR", "GBP":
+		tick = TickSize
+	}
+	return Product{
+		Id: id,
+		Base: parts[0],
+		Quote: parts[1],
+		Tick: tick,
+	}, nil
+}
+
+func (p Product) String() string {
+	return p.Id
+}
+
+// RoundPrice rounds price to the product's tick.
+func (p Product) RoundPrice(price float64) float64 {
+	return roundTo(price, p.Tick)
+}
+
+// roundTo rounds price to the nearest multiple of tick.
+func roundTo(price float64, tick float64) float64 {
+	return roundPlus(round(price / tick) * tick, 8)
+}
+
+// floorTo rounds price down to a multiple of tick.
+func floorTo(price float64, tick float64) float64 {
+	return roundPlus(math.Floor(price / tick) * tick, 8)
+}
+
+// ceilTo rounds price up to a multiple of tick.
+func ceilTo(price float64, tick float64) float64 {
+	return roundPlus(math.Ceil(price / tick) * tick, 8)
+}
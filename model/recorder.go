@@ -0,0 +1,142 @@
+package model
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	RecordSnapshot = "snapshot"
+	RecordMessage = "message"
+)
+
+// RecordEntry is one line of a recorded feed file: either a level-3
+// snapshot or a raw websocket frame, stamped with when we received it.
+type RecordEntry struct {
+	Time time.Time `json:"time"`
+	Type string `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Recorder writes one product's feed to gzipped, line-delimited JSON
+// files in dir. It starts a new file when the current one passes maxSize
+// compressed bytes or the UTC day changes, and every file begins with a
+// snapshot so each can be replayed on its own.
+type Recorder struct {
+	sync.Mutex
+	dir string
+	productId string
+	maxSize int64
+	snapshot func() (*OrderBook, error)
+	file *os.File
+	counter *countingWriter
+	gz *gzip.Writer
+	day string
+	retryAt time.Time
+}
+
+func NewRecorder(dir string, productId string, maxSize int64, snapshot func() (*OrderBook, error)) *Recorder {
+	return &Recorder{
+		dir: dir,
+		productId: productId,
+		maxSize: maxSize,
+		snapshot: snapshot,
+	}
+}
+
+// WriteMessage records a raw frame, rotating to a new file first if needed.
+func (r *Recorder) WriteMessage(raw []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now().UTC()
+	if r.file == nil || r.counter.n >= r.maxSize || now.Format("20060102") != r.day {
+		if now.Before(r.retryAt) {
+			return errors.New("waiting to retry snapshot")
+		}
+		if err := r.rotate(now); err != nil {
+			r.retryAt = now.Add(time.Second)
+			return err
+		}
+	}
+	return r.write(RecordEntry{Time: now, Type: RecordMessage, Data: raw})
+}
+
+// Rotate closes the current file, so the next message starts a new one
+// with a fresh snapshot. Call it when the feed may have missed messages.
+func (r *Recorder) Rotate() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.close()
+}
+
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.close()
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.close(); err != nil {
+		return err
+	}
+	ob, err := r.snapshot()
+	if err != nil {
+		return fmt.Errorf("failed to download snapshot: %v", err)
+	}
+	data, err := json.Marshal(ob)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Join(r.dir, fmt.Sprintf("%v-%v.jsonl.gz", r.productId, now.Format("20060102T150405.000")))
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("recording to %v", name)
+	r.file = file
+	r.counter = &countingWriter{w: file}
+	r.gz = gzip.NewWriter(r.counter)
+	r.day = now.Format("20060102")
+	return r.write(RecordEntry{Time: now, Type: RecordSnapshot, Data: data})
+}
+
+func (r *Recorder) write(entry RecordEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.gz.Write(append(line, '\n'))
+	return err
+}
+
+func (r *Recorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.gz.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
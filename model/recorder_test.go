@@ -0,0 +1,52 @@
+package model
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readRecording(t *testing.T, name string) []RecordEntry {
+	file, err := os.Open(name)
+	assert.Nil(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	assert.Nil(t, err)
+	entries := make([]RecordEntry, 0)
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		entry := RecordEntry{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRecorderStartsFilesWithSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	snapshots := 0
+	recorder := NewRecorder(dir, "BTC-USD", 1 << 20, func() (*OrderBook, error) {
+		snapshots++
+		return &OrderBook{Sequence: int64(snapshots)}, nil
+	})
+	assert.Nil(t, recorder.WriteMessage([]byte(`{"type":"open","sequence":2}`)))
+	assert.Nil(t, recorder.WriteMessage([]byte(`{"type":"done","sequence":3}`)))
+	assert.Nil(t, recorder.Rotate())
+	assert.Nil(t, recorder.WriteMessage([]byte(`{"type":"open","sequence":9}`)))
+	assert.Nil(t, recorder.Close())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, 2, snapshots)
+
+	entries := readRecording(t, files[0])
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, RecordSnapshot, entries[0].Type)
+	assert.Equal(t, RecordMessage, entries[1].Type)
+	assert.Equal(t, `{"type":"open","sequence":2}`, string(entries[1].Data))
+	assert.Equal(t, 2, len(readRecording(t, files[1])))
+}
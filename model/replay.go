@@ -0,0 +1,123 @@
+package model
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Replay plays back files written by a Recorder. Messages are sent on in
+// the order they were recorded, spaced out by their original timing
+// divided by speed, or as fast as possible if speed is zero. Snapshots are
+// held for the next call to Snapshot, which stands in for downloading one.
+type Replay struct {
+	sync.Mutex
+	cond *sync.Cond
+	files []string
+	speed float64
+	snapshot *OrderBook
+	done bool
+}
+
+func NewReplay(files []string, speed float64) *Replay {
+	r := &Replay{
+		files: files,
+		speed: speed,
+	}
+	r.cond = sync.NewCond(r)
+	return r
+}
+
+// Snapshot waits for the next recorded snapshot that hasn't been handed
+// out yet, and fails once the replay has run out.
+func (r *Replay) Snapshot() (*OrderBook, error) {
+	r.Lock()
+	defer r.Unlock()
+	for r.snapshot == nil && !r.done {
+		r.cond.Wait()
+	}
+	if r.snapshot == nil {
+		return nil, errors.New("end of replay")
+	}
+	ob := r.snapshot
+	r.snapshot = nil
+	return ob, nil
+}
+
+// Run sends every recorded message to msgChan and closes it at the end.
+func (r *Replay) Run(msgChan chan Message) error {
+	defer close(msgChan)
+	defer r.finish()
+	var last time.Time
+	for _, name := range r.files {
+		err := r.readFile(name, func(entry RecordEntry) error {
+			if r.speed > 0 && !last.IsZero() && entry.Time.After(last) {
+				time.Sleep(time.Duration(float64(entry.Time.Sub(last)) / r.speed))
+			}
+			last = entry.Time
+
+			if entry.Type == RecordSnapshot {
+				ob := &OrderBook{}
+				if err := json.Unmarshal(entry.Data, ob); err != nil {
+					return err
+				}
+				r.Lock()
+				r.snapshot = ob
+				r.cond.Broadcast()
+				r.Unlock()
+			} else if entry.Type == RecordMessage {
+				msg := Message{}
+				if err := json.Unmarshal(entry.Data, &msg); err != nil {
+					return err
+				}
+				msgChan <- msg
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replay) finish() {
+	r.Lock()
+	r.done = true
+	r.cond.Broadcast()
+	r.Unlock()
+}
+
+func (r *Replay) readFile(name string, fn func(RecordEntry) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	for {
+		entry := RecordEntry{}
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err == io.ErrUnexpectedEOF {
+			log.Printf("%v ends with a partial entry", name)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayPlaysBackRecording(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewRecorder(dir, "BTC-USD", 1 << 20, func() (*OrderBook, error) {
+		return &OrderBook{Sequence: 1, Bids: [][]string{{"100.00", "1.0", "b1"}}}, nil
+	})
+	recorder.WriteMessage([]byte(`{"type":"open","sequence":2,"order_id":"x"}`))
+	recorder.WriteMessage([]byte(`{"type":"done","sequence":3,"order_id":"x"}`))
+	recorder.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+
+	replay := NewReplay(files, 0)
+	msgChan := make(chan Message)
+	go replay.Run(msgChan)
+
+	ob, err := replay.Snapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ob.Sequence)
+	assert.Equal(t, 1, len(ob.BidOrders()))
+
+	msgs := make([]Message, 0)
+	for msg := range msgChan {
+		msgs = append(msgs, msg)
+	}
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, int64(3), msgs[1].Sequence)
+
+	_, err = replay.Snapshot()
+	assert.NotNil(t, err)
+}
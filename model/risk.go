@@ -0,0 +1,200 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"github.com/sirsean/marketmaker/config"
+)
+
+// RiskManager holds the hard limits every order we place is checked
+// against, and halts quoting on every product when our losses pass the
+// session or daily limit or when we're told to. A halt lasts until
+// Resume, or until each of its reasons is lifted. A nil RiskManager
+// allows everything.
+type RiskManager struct {
+	sync.Mutex
+	limits config.Risk
+	ledger *Ledger
+	// halted holds why quoting is halted, first reason first
+	halted []string
+	cancel bool
+}
+
+func NewRiskManager(limits config.Risk, ledger *Ledger) *RiskManager {
+	return &RiskManager{
+		limits: limits,
+		ledger: ledger,
+	}
+}
+
+// Configure swaps in new limits, for a config reload.
+func (r *RiskManager) Configure(limits config.Risk) {
+	r.Lock()
+	defer r.Unlock()
+	r.limits = limits
+}
+
+// Halted returns why quoting is halted, or "" if it isn't.
+func (r *RiskManager) Halted() string {
+	if r == nil {
+		return ""
+	}
+	r.Lock()
+	defer r.Unlock()
+	return r.reason()
+}
+
+// reason is the first reason quoting was halted for. The caller must
+// hold the lock.
+func (r *RiskManager) reason() string {
+	if len(r.halted) == 0 {
+		return ""
+	}
+	return r.halted[0]
+}
+
+// Halt stops new orders on every product, and has our resting orders
+// canceled too if cancel is set. Halting again adds another reason,
+// though Halted still reports the first, and can add the cancel.
+func (r *RiskManager) Halt(reason string, cancel bool) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.halted) == 0 {
+		log.Printf("HALTING: %v", reason)
+	}
+	if !r.haltedFor(reason) {
+		r.halted = append(r.halted, reason)
+	}
+	r.cancel = r.cancel || cancel
+}
+
+func (r *RiskManager) haltedFor(reason string) bool {
+	for _, h := range r.halted {
+		if h == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Resume lifts every halt. A loss limit that's still passed halts again.
+func (r *RiskManager) Resume() {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.halted) > 0 {
+		log.Printf("RESUMING after: %v", r.halted)
+	}
+	r.halted = nil
+	r.cancel = false
+}
+
+// Lift drops one reason for halting, resuming if it was the last.
+func (r *RiskManager) Lift(reason string) {
+	r.Lock()
+	defer r.Unlock()
+	if !r.haltedFor(reason) {
+		return
+	}
+	halted := make([]string, 0, len(r.halted))
+	for _, h := range r.halted {
+		if h != reason {
+			halted = append(halted, h)
+		}
+	}
+	r.halted = halted
+	if len(halted) == 0 {
+		log.Printf("RESUMING after: %v", reason)
+		r.cancel = false
+	}
+}
+
+// Breached checks our losses against the drawdown limits, halting if
+// they're passed, and reports whether quoting is halted and whether our
+// resting orders should be canceled.
+func (r *RiskManager) Breached() (halted bool, cancel bool) {
+	if r == nil {
+		return false, false
+	}
+	r.Lock()
+	limits := r.limits
+	halted, cancel = len(r.halted) > 0, r.cancel
+	r.Unlock()
+	if halted {
+		return
+	}
+	if limits.MaxSessionLoss <= 0 && limits.MaxDailyLoss <= 0 {
+		return false, false
+	}
+	session, today := 0.0, 0.0
+	for _, s := range r.ledger.Session() {
+		session += s.Pnl
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	for _, s := range r.ledger.Days() {
+		if s.Day == day {
+			today += s.Pnl
+		}
+	}
+	if limits.MaxSessionLoss > 0 && -session >= limits.MaxSessionLoss {
+		r.Halt(fmt.Sprintf("session loss %0.2f reached the limit of %v", -session, limits.MaxSessionLoss), true)
+	} else if limits.MaxDailyLoss > 0 && -today >= limits.MaxDailyLoss {
+		r.Halt(fmt.Sprintf("daily loss %0.2f reached the limit of %v", -today, limits.MaxDailyLoss), true)
+	}
+	r.Lock()
+	defer r.Unlock()
+	return len(r.halted) > 0, r.cancel
+}
+
+// Allow checks an order we're about to place against the limits, given
+// the mid (0 if there isn't one) and our other open orders on its side,
+// returning why it isn't allowed.
+func (r *RiskManager) Allow(o exchange.Order, mid float64, open []exchange.Order) error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	limits, halted := r.limits, r.reason()
+	r.Unlock()
+	if halted != "" {
+		return fmt.Errorf("quoting is halted: %v", halted)
+	}
+	if limits.MaxOrderSize > 0 && o.Size > limits.MaxOrderSize {
+		return fmt.Errorf("size %v is over the limit of %v", o.Size, limits.MaxOrderSize)
+	}
+	if limits.PriceCollar > 0 {
+		if mid <= 0 {
+			return fmt.Errorf("no mid to check price %v against", o.Price)
+		}
+		if math.Abs(o.Price - mid) / mid > limits.PriceCollar {
+			return fmt.Errorf("price %v is more than %v from the mid %v", o.Price, limits.PriceCollar, mid)
+		}
+	}
+	openSize, openNotional := 0.0, 0.0
+	for _, other := range open {
+		openSize += other.Size
+		openNotional += other.Size * other.Price
+	}
+	if limits.MaxOpenNotional > 0 && openNotional + o.Size * o.Price > limits.MaxOpenNotional {
+		return fmt.Errorf("open %v notional would be %0.2f, over the limit of %v", o.Side, openNotional + o.Size * o.Price, limits.MaxOpenNotional)
+	}
+	if limits.MaxPosition > 0 {
+		// assume every open order on this side fills; orders that bring
+		// the position back toward the limit are always allowed
+		before, after := r.ledger.Position(o.ProductId).Size, 0.0
+		if o.Side == "buy" {
+			before += openSize
+			after = before + o.Size
+		} else {
+			before -= openSize
+			after = before - o.Size
+		}
+		if math.Abs(after) > limits.MaxPosition && math.Abs(after) > math.Abs(before) {
+			return fmt.Errorf("position would be %0.8f, over the limit of %v", after, limits.MaxPosition)
+		}
+	}
+	return nil
+}
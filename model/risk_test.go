@@ -0,0 +1,113 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type RiskManagerTestSuite struct {
+	suite.Suite
+	ledger *Ledger
+	risk *RiskManager
+}
+
+func (s *RiskManagerTestSuite) SetupTest() {
+	s.ledger = NewLedger(time.Now().Add(-time.Hour))
+	s.risk = NewRiskManager(config.Risk{
+		MaxPosition: 1.0,
+		MaxOpenNotional: 250.0,
+		MaxOrderSize: 0.5,
+		PriceCollar: 0.05,
+		MaxSessionLoss: 10.0,
+	}, s.ledger)
+}
+
+func (s *RiskManagerTestSuite) buy(price float64, size float64) exchange.Order {
+	return exchange.Order{ProductId: "BTC-USD", Side: "buy", Price: price, Size: size}
+}
+
+func (s *RiskManagerTestSuite) TestOrderLimits() {
+	assert.NoError(s.T(), s.risk.Allow(s.buy(100.0, 0.5), 101.0, nil))
+	assert.Error(s.T(), s.risk.Allow(s.buy(100.0, 0.6), 101.0, nil))
+	assert.Error(s.T(), s.risk.Allow(s.buy(90.0, 0.1), 101.0, nil))
+	assert.Error(s.T(), s.risk.Allow(s.buy(100.0, 0.1), 0, nil))
+
+	open := []exchange.Order{s.buy(100.0, 0.5), s.buy(99.0, 0.5)}
+	assert.Error(s.T(), s.risk.Allow(s.buy(98.0, 0.5), 101.0, open))
+	assert.NoError(s.T(), s.risk.Allow(s.buy(98.0, 0.5), 101.0, open[:1]))
+}
+
+func (s *RiskManagerTestSuite) TestPositionLimit() {
+	s.ledger.Record(Fill{Time: time.Now(), ProductId: "BTC-USD", TradeId: 1, OrderId: "1", Side: "buy", Price: 100.0, Size: 0.8})
+	assert.Error(s.T(), s.risk.Allow(s.buy(100.0, 0.3), 101.0, nil))
+	assert.NoError(s.T(), s.risk.Allow(s.buy(100.0, 0.2), 101.0, nil))
+
+	// selling down a long is fine, however far it goes
+	s.ledger.Record(Fill{Time: time.Now(), ProductId: "BTC-USD", TradeId: 2, OrderId: "2", Side: "buy", Price: 100.0, Size: 0.7})
+	sell := exchange.Order{ProductId: "BTC-USD", Side: "sell", Price: 101.0, Size: 0.5}
+	assert.NoError(s.T(), s.risk.Allow(sell, 101.0, nil))
+	assert.Error(s.T(), s.risk.Allow(s.buy(100.0, 0.1), 101.0, nil))
+}
+
+func (s *RiskManagerTestSuite) TestLossHalts() {
+	s.ledger.Record(Fill{Time: time.Now(), ProductId: "BTC-USD", TradeId: 1, OrderId: "1", Side: "buy", Price: 100.0, Size: 1.0})
+	s.ledger.Mark("BTC-USD", 95.0)
+	halted, _ := s.risk.Breached()
+	assert.False(s.T(), halted)
+
+	s.ledger.Mark("BTC-USD", 90.0)
+	halted, cancel := s.risk.Breached()
+	assert.True(s.T(), halted)
+	assert.True(s.T(), cancel)
+	assert.NotEqual(s.T(), "", s.risk.Halted())
+
+	// halts stick, even once the loss comes back
+	s.ledger.Mark("BTC-USD", 100.0)
+	halted, _ = s.risk.Breached()
+	assert.True(s.T(), halted)
+	assert.Error(s.T(), s.risk.Allow(s.buy(100.0, 0.1), 101.0, nil))
+
+	s.risk.Resume()
+	halted, _ = s.risk.Breached()
+	assert.False(s.T(), halted)
+	assert.NoError(s.T(), s.risk.Allow(exchange.Order{ProductId: "BTC-USD", Side: "sell", Price: 101.0, Size: 0.5}, 101.0, nil))
+}
+
+func (s *RiskManagerTestSuite) TestHaltWithoutCancel() {
+	s.risk.Halt("operator", false)
+	halted, cancel := s.risk.Breached()
+	assert.True(s.T(), halted)
+	assert.False(s.T(), cancel)
+
+	s.risk.Halt("again", true)
+	_, cancel = s.risk.Breached()
+	assert.True(s.T(), cancel)
+	assert.Equal(s.T(), "operator", s.risk.Halted())
+}
+
+func (s *RiskManagerTestSuite) TestLiftOnlyDropsItsOwnHalt() {
+	s.risk.Halt("halt file", false)
+	s.risk.Halt("operator", false)
+	s.risk.Lift("halt file")
+	assert.Equal(s.T(), "operator", s.risk.Halted())
+	s.risk.Lift("halt file")
+	assert.Equal(s.T(), "operator", s.risk.Halted())
+	s.risk.Lift("operator")
+	halted, _ := s.risk.Breached()
+	assert.False(s.T(), halted)
+}
+
+func (s *RiskManagerTestSuite) TestNilAllowsEverything() {
+	var risk *RiskManager
+	assert.NoError(s.T(), risk.Allow(s.buy(1.0, 100.0), 0, nil))
+	halted, _ := risk.Breached()
+	assert.False(s.T(), halted)
+}
+
+func TestRiskManagerSuite(t *testing.T) {
+	suite.Run(t, new(RiskManagerTestSuite))
+}
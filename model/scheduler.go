@@ -0,0 +1,178 @@
+package model
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"github.com/sirsean/marketmaker/config"
+)
+
+// ErrBackpressure is returned for a new order when too many are already
+// waiting for the rate limit.
+var ErrBackpressure = errors.New("too many requests waiting for the rate limit")
+
+// priority orders the requests waiting for a token, most urgent first.
+type priority int
+
+const (
+	priorityCancel priority = iota
+	priorityRead
+	priorityCreate
+	priorities
+)
+
+// Scheduler is an Exchange that holds every call to the one it wraps to a
+// token bucket, so bursts of book changes can't get our API key throttled.
+// Waiting cancels go first, then reads, then new orders. Public endpoints
+// have their own bucket, through Public.
+type Scheduler struct {
+	client Exchange
+	private *limiter
+	public *limiter
+}
+
+func NewScheduler(client Exchange, limits config.RateLimit) *Scheduler {
+	return &Scheduler{
+		client: client,
+		private: newLimiter(limits.PrivateRate, limits.PrivateBurst, limits.MaxQueued),
+		public: newLimiter(limits.PublicRate, limits.PublicBurst, limits.MaxQueued),
+	}
+}
+
+// Configure swaps in new limits, for a config reload.
+func (s *Scheduler) Configure(limits config.RateLimit) {
+	s.private.configure(limits.PrivateRate, limits.PrivateBurst, limits.MaxQueued)
+	s.public.configure(limits.PublicRate, limits.PublicBurst, limits.MaxQueued)
+}
+
+func (s *Scheduler) GetAccounts() ([]exchange.Account, error) {
+	s.private.wait(priorityRead)
+	return s.client.GetAccounts()
+}
+
+func (s *Scheduler) ListOrders() ([]exchange.Order, error) {
+	s.private.wait(priorityRead)
+	return s.client.ListOrders()
+}
+
+func (s *Scheduler) CreateOrder(o *exchange.Order) (exchange.Order, error) {
+	if err := s.private.wait(priorityCreate); err != nil {
+		return exchange.Order{}, err
+	}
+	return s.client.CreateOrder(o)
+}
+
+func (s *Scheduler) CancelOrder(id string) error {
+	s.private.wait(priorityCancel)
+	return s.client.CancelOrder(id)
+}
+
+func (s *Scheduler) RecentFills(productId string) ([]Fill, error) {
+	s.private.wait(priorityRead)
+	return s.client.RecentFills(productId)
+}
+
+// Public calls fn, which hits a public endpoint, once the public bucket
+// has a token for it. A nil Scheduler calls it right away.
+func (s *Scheduler) Public(fn func() error) error {
+	if s == nil {
+		return fn()
+	}
+	s.public.wait(priorityRead)
+	return fn()
+}
+
+// limiter hands a token bucket's tokens out to waiting requests.
+type limiter struct {
+	sync.Mutex
+	rate float64
+	burst float64
+	maxQueued int
+	tokens float64
+	last time.Time
+	queues [priorities][]chan struct{}
+	wake chan struct{}
+}
+
+func newLimiter(rate float64, burst int, maxQueued int) *limiter {
+	l := &limiter{
+		rate: rate,
+		burst: float64(burst),
+		maxQueued: maxQueued,
+		tokens: float64(burst),
+		last: time.Now(),
+		wake: make(chan struct{}, 1),
+	}
+	go l.run()
+	return l
+}
+
+func (l *limiter) configure(rate float64, burst int, maxQueued int) {
+	l.Lock()
+	l.rate, l.burst, l.maxQueued = rate, float64(burst), maxQueued
+	l.Unlock()
+	l.poke()
+}
+
+// wait blocks until the request gets a token. Only new orders can be
+// refused; cancels and reads always wait their turn.
+func (l *limiter) wait(p priority) error {
+	ready := make(chan struct{})
+	l.Lock()
+	if p == priorityCreate && len(l.queues[p]) >= l.maxQueued {
+		l.Unlock()
+		return ErrBackpressure
+	}
+	l.queues[p] = append(l.queues[p], ready)
+	l.Unlock()
+	l.poke()
+	<-ready
+	return nil
+}
+
+func (l *limiter) poke() {
+	select {
+		case l.wake <- struct{}{}:
+		default:
+	}
+}
+
+// run gives each token to the most urgent request waiting, sleeping
+// while there are no tokens or no requests.
+func (l *limiter) run() {
+	for {
+		l.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens + now.Sub(l.last).Seconds() * l.rate)
+		l.last = now
+		var ready chan struct{}
+		waiting := false
+		for p := range l.queues {
+			if len(l.queues[p]) == 0 {
+				continue
+			}
+			waiting = true
+			if l.tokens >= 1 {
+				ready = l.queues[p][0]
+				l.queues[p] = l.queues[p][1:]
+				l.tokens--
+			}
+			break
+		}
+		refilled := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.Unlock()
+
+		if ready != nil {
+			close(ready)
+		} else if waiting {
+			select {
+				case <-time.After(refilled):
+				case <-l.wake:
+			}
+		} else {
+			<-l.wake
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"sync"
+	"testing"
+	"time"
+)
+
+type SchedulerTestSuite struct {
+	suite.Suite
+}
+
+// queued waits until n requests of priority p are waiting on l.
+func (s *SchedulerTestSuite) queued(l *limiter, p priority, n int) {
+	for i := 0; i < 100; i++ {
+		l.Lock()
+		waiting := len(l.queues[p])
+		l.Unlock()
+		if waiting == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.T().Fatalf("%v requests never queued", n)
+}
+
+func (s *SchedulerTestSuite) TestRate() {
+	l := newLimiter(50, 2, 10)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.NoError(s.T(), l.wait(priorityRead))
+	}
+	assert.True(s.T(), time.Since(start) >= 70 * time.Millisecond)
+}
+
+func (s *SchedulerTestSuite) TestCancelsGoFirst() {
+	l := newLimiter(10, 1, 10)
+	l.wait(priorityRead)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := make([]priority, 0)
+	for _, p := range []priority{priorityCreate, priorityRead, priorityCancel} {
+		wg.Add(1)
+		go func(p priority) {
+			l.wait(p)
+			mu.Lock()
+			done = append(done, p)
+			mu.Unlock()
+			wg.Done()
+		}(p)
+		s.queued(l, p, 1)
+	}
+	wg.Wait()
+	assert.Equal(s.T(), []priority{priorityCancel, priorityRead, priorityCreate}, done)
+}
+
+func (s *SchedulerTestSuite) TestBackpressure() {
+	l := newLimiter(1, 1, 2)
+	l.wait(priorityCreate)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			assert.NoError(s.T(), l.wait(priorityCreate))
+			wg.Done()
+		}()
+	}
+	s.queued(l, priorityCreate, 2)
+	assert.Equal(s.T(), ErrBackpressure, l.wait(priorityCreate))
+
+	l.configure(1000, 1, 2)
+	wg.Wait()
+	assert.NoError(s.T(), l.wait(priorityCreate))
+}
+
+func (s *SchedulerTestSuite) TestPublic() {
+	var nilScheduler *Scheduler
+	calls := 0
+	assert.NoError(s.T(), nilScheduler.Public(func() error { calls++; return nil }))
+
+	paper := NewPaperExchange(map[string]float64{"USD": 100.0}, 0.0)
+	scheduler := NewScheduler(paper, config.Defaults().RateLimit)
+	assert.NoError(s.T(), scheduler.Public(func() error { calls++; return nil }))
+	assert.Equal(s.T(), 2, calls)
+	accounts, err := scheduler.GetAccounts()
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 1, len(accounts))
+}
+
+func TestSchedulerSuite(t *testing.T) {
+	suite.Run(t, new(SchedulerTestSuite))
+}
@@ -0,0 +1,307 @@
+package model
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	bolt "go.etcd.io/bbolt"
+)
+
+// OrderGone marks an order we last knew as live but that the exchange no
+// longer listed when we started up. Only the store uses it, since we
+// can't tell how the order ended.
+const OrderGone OrderState = "gone"
+
+var (
+	ordersBucket = []byte("orders")
+	orderIdsBucket = []byte("order_ids")
+	transitionsBucket = []byte("transitions")
+	fillsBucket = []byte("fills")
+	balancesBucket = []byte("balances")
+)
+
+// Transition is one change in an order's state, kept as a journal.
+type Transition struct {
+	Time time.Time
+	ClientOID string
+	Id string
+	State OrderState
+}
+
+// BalanceSnapshot is the account's available balances at one moment.
+type BalanceSnapshot struct {
+	Time time.Time
+	Balances map[string]float64
+}
+
+// Store keeps our orders, their transitions, our fills and balance
+// snapshots in a BoltDB file, so a restart can pick up our fills and
+// orders where we left off. The balance snapshots are only a history.
+// A nil Store records nothing, and failures to record are logged rather
+// than stopping trading.
+type Store struct {
+	db *bolt.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{ordersBucket, orderIdsBucket, transitionsBucket, fillsBucket, balancesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// SaveOrder records the latest we know of one of our orders. Orders are
+// keyed by client id, since we have that before the exchange gives us
+// one, and each change of state is journaled.
+func (s *Store) SaveOrder(t TrackedOrder) {
+	if s == nil {
+		return
+	}
+	s.update(func(tx *bolt.Tx) error {
+		return saveOrder(tx, t)
+	})
+}
+
+// ReconcileOrders compares the orders we last thought were live with the
+// ones the exchange lists. Ours that it doesn't list were closed while we
+// weren't watching; the ones we didn't know about are adopted.
+func (s *Store) ReconcileOrders(orders []exchange.Order) {
+	if s == nil {
+		return
+	}
+	listed := make(map[string]exchange.Order)
+	for _, o := range orders {
+		listed[o.Id] = o
+	}
+	s.update(func(tx *bolt.Tx) error {
+		live := make([]TrackedOrder, 0)
+		err := tx.Bucket(ordersBucket).ForEach(func(k, v []byte) error {
+			t := TrackedOrder{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			if t.IsLive() {
+				live = append(live, t)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, t := range live {
+			if _, ok := listed[t.Id]; ok && t.Id != "" {
+				delete(listed, t.Id)
+				continue
+			}
+			log.Printf("order %v (%v) closed while we were away", t.Id, t.ClientOID)
+			t.State = OrderGone
+			t.Updated = time.Now()
+			if err := saveOrder(tx, t); err != nil {
+				return err
+			}
+		}
+		for _, o := range listed {
+			t := adoptOrder(o)
+			if key := tx.Bucket(orderIdsBucket).Get([]byte(o.Id)); key != nil {
+				t.ClientOID = string(key)
+			}
+			if err := saveOrder(tx, *t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Order returns the order we recorded under the exchange's id, if we
+// have one. A nil Store has none.
+func (s *Store) Order(id string) (TrackedOrder, bool) {
+	t := TrackedOrder{}
+	if s == nil || id == "" {
+		return t, false
+	}
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket(orderIdsBucket).Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+		v := tx.Bucket(ordersBucket).Get(key)
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &t)
+	})
+	if err != nil {
+		log.Printf("failed to load order %v: %v", id, err)
+		return t, false
+	}
+	return t, found
+}
+
+// Orders returns every order we've recorded.
+func (s *Store) Orders() ([]TrackedOrder, error) {
+	orders := make([]TrackedOrder, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ordersBucket).ForEach(func(k, v []byte) error {
+			t := TrackedOrder{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			orders = append(orders, t)
+			return nil
+		})
+	})
+	return orders, err
+}
+
+// Transitions returns the journal of order state changes, oldest first.
+func (s *Store) Transitions() ([]Transition, error) {
+	transitions := make([]Transition, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(transitionsBucket).ForEach(func(k, v []byte) error {
+			t := Transition{}
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			transitions = append(transitions, t)
+			return nil
+		})
+	})
+	return transitions, err
+}
+
+// RecordFills saves fills, replacing any we already have with the same
+// trade, which is how fees get filled in.
+func (s *Store) RecordFills(fills []Fill) {
+	if s == nil || len(fills) == 0 {
+		return
+	}
+	s.update(func(tx *bolt.Tx) error {
+		for _, f := range fills {
+			if err := putJson(tx.Bucket(fillsBucket), []byte(ledgerKey(f)), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Store) Fills() ([]Fill, error) {
+	fills := make([]Fill, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(fillsBucket).ForEach(func(k, v []byte) error {
+			f := Fill{}
+			if err := json.Unmarshal(v, &f); err != nil {
+				return err
+			}
+			fills = append(fills, f)
+			return nil
+		})
+	})
+	return fills, err
+}
+
+// RecordBalances snapshots the available balances.
+func (s *Store) RecordBalances(balances map[string]float64) {
+	if s == nil {
+		return
+	}
+	snapshot := BalanceSnapshot{Time: time.Now(), Balances: balances}
+	s.update(func(tx *bolt.Tx) error {
+		key := []byte(snapshot.Time.UTC().Format(time.RFC3339Nano))
+		return putJson(tx.Bucket(balancesBucket), key, snapshot)
+	})
+}
+
+// LastBalances returns the latest balance snapshot, if there is one.
+func (s *Store) LastBalances() (BalanceSnapshot, error) {
+	snapshot := BalanceSnapshot{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(balancesBucket).Cursor().Last()
+		if v == nil {
+			return errors.New("no balances recorded")
+		}
+		return json.Unmarshal(v, &snapshot)
+	})
+	return snapshot, err
+}
+
+func (s *Store) update(fn func(tx *bolt.Tx) error) {
+	if err := s.db.Update(fn); err != nil {
+		log.Printf("failed to update store: %v", err)
+	}
+}
+
+// saveOrder stores t, indexes it by the exchange's id once it has one,
+// and journals its state if that changed.
+func saveOrder(tx *bolt.Tx, t TrackedOrder) error {
+	b := tx.Bucket(ordersBucket)
+	key := []byte(t.ClientOID)
+	previous := TrackedOrder{}
+	if v := b.Get(key); v != nil {
+		if err := json.Unmarshal(v, &previous); err != nil {
+			return err
+		}
+	}
+	if err := putJson(b, key, t); err != nil {
+		return err
+	}
+	if t.Id != "" {
+		if err := tx.Bucket(orderIdsBucket).Put([]byte(t.Id), key); err != nil {
+			return err
+		}
+	}
+	if previous.State == t.State {
+		return nil
+	}
+	return addTransition(tx, t)
+}
+
+func addTransition(tx *bolt.Tx, t TrackedOrder) error {
+	b := tx.Bucket(transitionsBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return putJson(b, key, Transition{
+		Time: t.Updated,
+		ClientOID: t.ClientOID,
+		Id: t.Id,
+		State: t.State,
+	})
+}
+
+func putJson(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
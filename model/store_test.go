@@ -0,0 +1,118 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type StoreTestSuite struct {
+	suite.Suite
+	dir string
+	store *Store
+}
+
+func (s *StoreTestSuite) SetupTest() {
+	s.dir, _ = ioutil.TempDir("", "store")
+	store, err := OpenStore(filepath.Join(s.dir, "test.db"))
+	assert.Nil(s.T(), err)
+	s.store = store
+}
+
+func (s *StoreTestSuite) TearDownTest() {
+	s.store.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *StoreTestSuite) reopen() {
+	assert.Nil(s.T(), s.store.Close())
+	store, err := OpenStore(filepath.Join(s.dir, "test.db"))
+	assert.Nil(s.T(), err)
+	s.store = store
+}
+
+func (s *StoreTestSuite) orders() map[string]TrackedOrder {
+	orders, err := s.store.Orders()
+	assert.Nil(s.T(), err)
+	byId := make(map[string]TrackedOrder)
+	for _, o := range orders {
+		byId[o.ClientOID] = o
+	}
+	return byId
+}
+
+func (s *StoreTestSuite) TestOrderLifecycle() {
+	c1 := NewTrackedOrder(exchange.Order{ClientOID: "c1", ProductId: "BTC-USD", Side: "buy", Price: 100.0, Size: 1.0})
+	c2 := NewTrackedOrder(exchange.Order{ClientOID: "c2", ProductId: "BTC-USD", Side: "sell", Price: 101.0, Size: 1.0})
+	c3 := NewTrackedOrder(exchange.Order{ClientOID: "c3", ProductId: "BTC-USD", Side: "sell", Price: 102.0, Size: 1.0})
+	for _, t := range []*TrackedOrder{c1, c2, c3} {
+		s.store.SaveOrder(*t)
+	}
+	c1.Acknowledge("1")
+	s.store.SaveOrder(*c1)
+	c2.Acknowledge("2")
+	s.store.SaveOrder(*c2)
+	c1.Fill(0.6, 100.0)
+	s.store.SaveOrder(*c1)
+	c3.Reject()
+	s.store.SaveOrder(*c3)
+	s.reopen()
+
+	orders := s.orders()
+	assert.Equal(s.T(), OrderPartiallyFilled, orders["c1"].State)
+	stored := orders["c1"]
+	assert.Equal(s.T(), 0.4, stored.Remaining())
+	assert.Equal(s.T(), OrderRejected, orders["c3"].State)
+
+	s.store.ReconcileOrders([]exchange.Order{
+		{Id: "1", ProductId: "BTC-USD", Side: "buy", Price: 100.0, Size: 0.4},
+		{Id: "9", ProductId: "BTC-USD", Side: "buy", Price: 99.0, Size: 1.0, FilledSize: 0.25},
+	})
+	orders = s.orders()
+	assert.Equal(s.T(), OrderPartiallyFilled, orders["c1"].State)
+	assert.Equal(s.T(), OrderGone, orders["c2"].State)
+	assert.Equal(s.T(), OrderPartiallyFilled, orders["9"].State)
+	stored = orders["9"]
+	assert.Equal(s.T(), 0.75, stored.Remaining())
+
+	transitions, err := s.store.Transitions()
+	assert.Nil(s.T(), err)
+	states := make([]OrderState, 0)
+	for _, t := range transitions {
+		if t.ClientOID == "c2" {
+			states = append(states, t.State)
+		}
+	}
+	assert.Equal(s.T(), []OrderState{OrderPendingNew, OrderOpen, OrderGone}, states)
+}
+
+func (s *StoreTestSuite) TestFillsAndBalances() {
+	s.store.RecordFills([]Fill{{ProductId: "BTC-USD", TradeId: 1, OrderId: "1", Side: "buy", Price: 100.0, Size: 1.0}})
+	s.store.RecordFills([]Fill{{ProductId: "BTC-USD", TradeId: 1, OrderId: "1", Side: "buy", Price: 100.0, Size: 1.0, Fee: 0.25}})
+	s.store.RecordBalances(map[string]float64{"USD": 10.0})
+	s.store.RecordBalances(map[string]float64{"USD": 20.0})
+	s.reopen()
+
+	fills, err := s.store.Fills()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, len(fills))
+	assert.Equal(s.T(), 0.25, fills[0].Fee)
+	last, err := s.store.LastBalances()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 20.0, last.Balances["USD"])
+}
+
+func (s *StoreTestSuite) TestNilStoreRecordsNothing() {
+	var store *Store
+	store.SaveOrder(TrackedOrder{ClientOID: "c1"})
+	store.RecordFills([]Fill{{TradeId: 1}})
+	assert.Nil(s.T(), store.Close())
+}
+
+func TestStoreSuite(t *testing.T) {
+	suite.Run(t, new(StoreTestSuite))
+}
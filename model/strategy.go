@@ -0,0 +1,106 @@
+package model
+
+import (
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+	"github.com/sirsean/marketmaker/config"
+	"fmt"
+)
+
+// Quote is an order a strategy wants resting on the book.
+type Quote struct {
+	Side string
+	Price float64
+	Size float64
+}
+
+// StrategyState is everything a strategy gets to look at when deciding
+// where to quote. Buys and Sells include orders still pending.
+type StrategyState struct {
+	Book *LocalBook
+	BestBid float64
+	BestAsk float64
+	Tick float64
+	AvailableBase float64
+	AvailableQuote float64
+	Buys []exchange.Order
+	Sells []exchange.Order
+	Stats []TradeStats
+}
+
+// BaseValue is what's available to this product, including funds held
+// by our orders, valued in the base currency at the best bid.
+func (s StrategyState) BaseValue() float64 {
+	if s.BestBid <= 0 {
+		return 0
+	}
+	base := s.AvailableBase + s.AvailableQuote / s.BestBid
+	for _, o := range s.Buys {
+		base += o.Size * o.Price / s.BestBid
+	}
+	for _, o := range s.Sells {
+		base += o.Size
+	}
+	return base
+}
+
+// tick is the price increment to quote on, cents unless told otherwise.
+func (s StrategyState) tick() float64 {
+	if s.Tick > 0 {
+		return s.Tick
+	}
+	return TickSize
+}
+
+// relative is an amount of price given in basis points when bps is set,
+// and the absolute amount otherwise.
+func relative(absolute float64, bps float64, price float64) float64 {
+	if bps > 0 {
+		return bps / 10000 * price
+	}
+	return absolute
+}
+
+// Strategy decides which quotes we want on the book. MyOrders takes care
+// of getting from the orders we have to the ones the strategy asks for.
+type Strategy interface {
+	Quotes(state StrategyState) []Quote
+}
+
+func NewStrategy(cfg config.Config) (Strategy, error) {
+	switch cfg.Strategy.Name {
+	case "ladder":
+		return NewLadderStrategy(cfg), nil
+	case "skew":
+		return NewSkewStrategy(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy: %v", cfg.Strategy.Name)
+	}
+}
+
+// matchQuotes pairs quotes with orders on the same side at the same price.
+// It returns the quotes that have no order yet and the orders that no
+// quote asks for.
+func matchQuotes(quotes []Quote, orders []exchange.Order) ([]Quote, []exchange.Order) {
+	wanted := make(map[float64]int)
+	for _, q := range quotes {
+		wanted[roundPlus(q.Price, 8)]++
+	}
+	extra := make([]exchange.Order, 0)
+	for _, o := range orders {
+		price := roundPlus(o.Price, 8)
+		if wanted[price] > 0 {
+			wanted[price]--
+		} else {
+			extra = append(extra, o)
+		}
+	}
+	missing := make([]Quote, 0)
+	for _, q := range quotes {
+		price := roundPlus(q.Price, 8)
+		if wanted[price] > 0 {
+			wanted[price]--
+			missing = append(missing, q)
+		}
+	}
+	return missing, extra
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+)
+
+// LadderStrategy is the original quoting policy: fixed size orders
+// stepped away from the touch, no further than the band, until half the
+// account's value is quoted on each side or the funds run out.
+type LadderStrategy struct {
+	Size float64
+	Band float64
+	Step float64
+	BandBps float64
+	StepBps float64
+	Cycle int
+	MaxFraction float64
+}
+
+func NewLadderStrategy(cfg config.Config) *LadderStrategy {
+	return &LadderStrategy{
+		Size: cfg.Strategy.Size,
+		Band: cfg.Strategy.Band,
+		Step: cfg.Strategy.Step,
+		BandBps: cfg.Strategy.BandBps,
+		StepBps: cfg.Strategy.StepBps,
+		Cycle: cfg.Strategy.Cycle,
+		MaxFraction: cfg.Risk.MaxQuotedFraction,
+	}
+}
+
+func (s *LadderStrategy) Quotes(state StrategyState) []Quote {
+	quotes := make([]Quote, 0)
+	if state.BestBid <= 0 || state.BestAsk <= 0 {
+		return quotes
+	}
+	maxSize := state.BaseValue() * s.MaxFraction
+
+	quote := state.AvailableQuote
+	for _, o := range state.Buys {
+		quote += o.Price * o.Size
+	}
+	band, step := relative(s.Band, s.BandBps, state.BestBid), relative(s.Step, s.StepBps, state.BestBid)
+	current := state.BestBid
+	for x, total := 0, 0.0; total < maxSize; x++ {
+		price := roundTo(current, state.tick())
+		if price < state.BestBid - band || quote < price * s.Size {
+			break
+		}
+		quotes = append(quotes, Quote{Side: "buy", Price: price, Size: s.Size})
+		quote -= price * s.Size
+		total += s.Size
+		current -= step * float64(x % s.Cycle)
+	}
+
+	base := state.AvailableBase
+	for _, o := range state.Sells {
+		base += o.Size
+	}
+	band, step = relative(s.Band, s.BandBps, state.BestAsk), relative(s.Step, s.StepBps, state.BestAsk)
+	current = state.BestAsk
+	for x, total := 0, 0.0; total < maxSize; x++ {
+		price := roundTo(current, state.tick())
+		if price > state.BestAsk + band || base < s.Size {
+			break
+		}
+		quotes = append(quotes, Quote{Side: "sell", Price: price, Size: s.Size})
+		base -= s.Size
+		total += s.Size
+		current += step * float64(x % s.Cycle)
+	}
+	return quotes
+}
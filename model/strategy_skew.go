@@ -0,0 +1,92 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+	"math"
+)
+
+// SkewStrategy quotes around a reservation price rather than the touch,
+// after Avellaneda and Stoikov: the further our holdings of the base
+// currency are from the target share of the account, the further the
+// reservation price moves from mid, so the side that would rebalance us
+// gets filled first. The spread widens with volatility and with our risk
+// aversion, gamma, and the ladder spreads out when short term volatility
+// runs above long term.
+type SkewStrategy struct {
+	Gamma float64
+	Kappa float64
+	Horizon float64
+	Target float64
+	Levels int
+	Size float64
+	Step float64
+	StepBps float64
+	MaxFraction float64
+}
+
+func NewSkewStrategy(cfg config.Config) *SkewStrategy {
+	return &SkewStrategy{
+		Gamma: cfg.Strategy.Gamma,
+		Kappa: cfg.Strategy.Kappa,
+		Horizon: cfg.Strategy.Horizon,
+		Target: cfg.Strategy.Target,
+		Levels: cfg.Strategy.Levels,
+		Size: cfg.Strategy.Size,
+		Step: cfg.Strategy.Step,
+		StepBps: cfg.Strategy.StepBps,
+		MaxFraction: cfg.Risk.MaxQuotedFraction,
+	}
+}
+
+func (s *SkewStrategy) Quotes(state StrategyState) []Quote {
+	quotes := make([]Quote, 0)
+	if state.BestBid <= 0 || state.BestAsk <= 0 {
+		return quotes
+	}
+	mid := (state.BestBid + state.BestAsk) / 2
+	sigma, step := 0.0, relative(s.Step, s.StepBps, mid)
+	if len(state.Stats) > 0 {
+		short := state.Stats[0].Volatility
+		long := state.Stats[len(state.Stats) - 1].Volatility
+		sigma = mid * short
+		if short > 0 && long > 0 {
+			step *= math.Max(0.5, math.Min(short / long, 3))
+		}
+	}
+
+	base := state.AvailableBase
+	for _, o := range state.Sells {
+		base += o.Size
+	}
+	quote := state.AvailableQuote
+	for _, o := range state.Buys {
+		quote += o.Price * o.Size
+	}
+	value := state.BaseValue()
+	inventory := base - s.Target * value
+	maxLevels := int(value * s.MaxFraction / s.Size)
+
+	risk := s.Gamma * sigma * sigma * s.Horizon
+	reservation := mid - inventory * risk
+	halfSpread := risk / 2 + math.Log(1 + s.Gamma / s.Kappa) / s.Gamma
+
+	for i := 0; i < s.Levels && i < maxLevels; i++ {
+		price := floorTo(reservation - halfSpread - float64(i) * step, state.tick())
+		price = math.Min(price, state.BestAsk - state.tick())
+		if price <= 0 || quote < price * s.Size {
+			break
+		}
+		quotes = append(quotes, Quote{Side: "buy", Price: price, Size: s.Size})
+		quote -= price * s.Size
+	}
+	for i := 0; i < s.Levels && i < maxLevels; i++ {
+		price := ceilTo(reservation + halfSpread + float64(i) * step, state.tick())
+		price = math.Max(price, state.BestBid + state.tick())
+		if base < s.Size {
+			break
+		}
+		quotes = append(quotes, Quote{Side: "sell", Price: price, Size: s.Size})
+		base -= s.Size
+	}
+	return quotes
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"github.com/sirsean/marketmaker/config"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+func TestLadderStrategyQuotes(t *testing.T) {
+	quotes := NewLadderStrategy(config.Defaults()).Quotes(StrategyState{
+		BestBid: 100.0,
+		BestAsk: 100.5,
+		AvailableBase: 1.0,
+		AvailableQuote: 100.0,
+	})
+	prices := map[string][]float64{}
+	for _, q := range quotes {
+		assert.Equal(t, 0.01, q.Size)
+		prices[q.Side] = append(prices[q.Side], q.Price)
+	}
+	assert.Equal(t, []float64{100.0, 100.0, 99.99, 99.97, 99.97, 99.96}, prices["buy"])
+	assert.Equal(t, []float64{100.5, 100.5, 100.51, 100.53, 100.53, 100.54}, prices["sell"])
+}
+
+func TestLadderStrategyBasisPoints(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Strategy.StepBps = 10
+	cfg.Strategy.BandBps = 30
+	quotes := NewLadderStrategy(cfg).Quotes(StrategyState{
+		BestBid: 100.0,
+		BestAsk: 100.5,
+		AvailableBase: 0.0,
+		AvailableQuote: 100.0,
+	})
+	prices := make([]float64, 0)
+	for _, q := range quotes {
+		prices = append(prices, q.Price)
+	}
+	assert.Equal(t, []float64{100.0, 100.0, 99.9, 99.7, 99.7}, prices)
+}
+
+func TestLadderStrategyRespectsFunds(t *testing.T) {
+	quotes := NewLadderStrategy(config.Defaults()).Quotes(StrategyState{
+		BestBid: 100.0,
+		BestAsk: 100.5,
+		AvailableBase: 0.015,
+		AvailableQuote: 2.5,
+		Buys: []exchange.Order{{Price: 99.0, Size: 0.01}},
+	})
+	sides := map[string]int{}
+	for _, q := range quotes {
+		sides[q.Side]++
+	}
+	assert.Equal(t, 3, sides["buy"])
+	assert.Equal(t, 1, sides["sell"])
+}
+
+func TestMatchQuotes(t *testing.T) {
+	quotes := []Quote{{"buy", 100.0, 0.01}, {"buy", 100.0, 0.01}, {"buy", 99.99, 0.01}}
+	orders := []exchange.Order{{Id: "1", Price: 100.0}, {Id: "2", Price: 99.98}}
+	missing, extra := matchQuotes(quotes, orders)
+	assert.Equal(t, []Quote{{"buy", 100.0, 0.01}, {"buy", 99.99, 0.01}}, missing)
+	assert.Equal(t, 1, len(extra))
+	assert.Equal(t, "2", extra[0].Id)
+}
+
+func TestSkewStrategyLeansAgainstInventory(t *testing.T) {
+	quotes := func(btc, usd float64) (float64, float64) {
+		cfg := config.Defaults()
+		cfg.Strategy.Levels = 1
+		s := NewSkewStrategy(cfg)
+		stats := []TradeStats{{Window: time.Minute, Volatility: 0.001}}
+		bid, ask := 0.0, 0.0
+		for _, q := range s.Quotes(StrategyState{BestBid: 99.9, BestAsk: 100.1, AvailableBase: btc, AvailableQuote: usd, Stats: stats}) {
+			if q.Side == "buy" {
+				bid = q.Price
+			} else {
+				ask = q.Price
+			}
+		}
+		return bid, ask
+	}
+
+	bid, ask := quotes(1.0, 100.0)
+	assert.True(t, bid < 100.0 && ask > 100.0)
+	assert.InDelta(t, 100.0 - bid, ask - 100.0, 0.011)
+
+	longBid, longAsk := quotes(1.9, 10.0)
+	assert.True(t, longBid < bid)
+	assert.True(t, longAsk < ask)
+
+	shortBid, shortAsk := quotes(0.1, 190.0)
+	assert.True(t, shortBid > bid)
+	assert.True(t, shortAsk > ask)
+}
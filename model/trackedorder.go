@@ -0,0 +1,246 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type OrderState string
+
+const (
+	OrderPendingNew OrderState = "pending-new"
+	OrderOpen OrderState = "open"
+	OrderPartiallyFilled OrderState = "partially-filled"
+	OrderPendingCancel OrderState = "pending-cancel"
+	OrderCanceled OrderState = "canceled"
+	OrderFilled OrderState = "filled"
+	OrderRejected OrderState = "rejected"
+)
+
+// orderTransitions lists the states each state can move to. Fills can
+// still arrive while a cancel is pending, and a cancel that fails leaves
+// the order where it was.
+var orderTransitions = map[OrderState][]OrderState{
+	OrderPendingNew: {OrderOpen, OrderPartiallyFilled, OrderFilled, OrderCanceled, OrderRejected},
+	OrderOpen: {OrderPartiallyFilled, OrderFilled, OrderPendingCancel, OrderCanceled},
+	OrderPartiallyFilled: {OrderPartiallyFilled, OrderFilled, OrderPendingCancel, OrderCanceled},
+	OrderPendingCancel: {OrderPendingCancel, OrderOpen, OrderPartiallyFilled, OrderFilled, OrderCanceled},
+}
+
+// OrderTransition is one change of a tracked order's state.
+type OrderTransition struct {
+	Time time.Time
+	From OrderState
+	To OrderState
+}
+
+// TrackedOrder is one of our orders from the moment we decide to place it
+// until it's done. Size is what's left of the order after any resizes,
+// filled or not, so Size - FilledSize is what still rests on the book.
+type TrackedOrder struct {
+	ClientOID string
+	Id string
+	ProductId string
+	Side string
+	Price float64
+	Size float64
+	FilledSize float64
+	FilledValue float64
+	State OrderState
+	Transitions []OrderTransition
+	Created time.Time
+	Updated time.Time
+}
+
+func NewTrackedOrder(o exchange.Order) *TrackedOrder {
+	now := time.Now()
+	return &TrackedOrder{
+		ClientOID: o.ClientOID,
+		Id: o.Id,
+		ProductId: o.ProductId,
+		Side: o.Side,
+		Price: o.Price,
+		Size: o.Size,
+		State: OrderPendingNew,
+		Created: now,
+		Updated: now,
+	}
+}
+
+// adoptOrder tracks an open order the exchange told us about, which we
+// may not have placed in this session.
+func adoptOrder(o exchange.Order) *TrackedOrder {
+	t := NewTrackedOrder(o)
+	if t.ClientOID == "" {
+		t.ClientOID = o.Id
+	}
+	t.FilledSize = o.FilledSize
+	t.FilledValue = o.FilledSize * o.Price
+	t.State = OrderOpen
+	if created := time.Time(o.CreatedAt); !created.IsZero() {
+		t.Created = created
+	}
+	if o.FilledSize > 0 {
+		t.State = OrderPartiallyFilled
+	}
+	return t
+}
+
+func (t *TrackedOrder) Remaining() float64 {
+	return roundPlus(t.Size - t.FilledSize, 8)
+}
+
+// AvgPrice is the average price we've been filled at so far.
+func (t *TrackedOrder) AvgPrice() float64 {
+	if t.FilledSize <= 0 {
+		return 0
+	}
+	return t.FilledValue / t.FilledSize
+}
+
+// IsLive is true until the order is done one way or another.
+func (t *TrackedOrder) IsLive() bool {
+	_, ok := orderTransitions[t.State]
+	return ok
+}
+
+// IsResting is true for orders the exchange has and that we aren't
+// trying to cancel.
+func (t *TrackedOrder) IsResting() bool {
+	return t.State == OrderOpen || t.State == OrderPartiallyFilled
+}
+
+// Held is the currency and amount our account holds for what's left of
+// the order, which for a buy includes the fee at rate fee.
+func (t *TrackedOrder) Held(product Product, fee float64) (string, float64) {
+	if t.Side == "buy" {
+		return product.Quote, t.Remaining() * t.Price * (1 + fee)
+	}
+	return product.Base, t.Remaining()
+}
+
+func (t *TrackedOrder) transition(to OrderState) error {
+	for _, allowed := range orderTransitions[t.State] {
+		if allowed == to {
+			now := time.Now()
+			t.Transitions = append(t.Transitions, OrderTransition{Time: now, From: t.State, To: to})
+			t.State = to
+			t.Updated = now
+			return nil
+		}
+	}
+	return fmt.Errorf("order %v can't go from %v to %v", t.key(), t.State, to)
+}
+
+// Acknowledge records the id the exchange gave the order.
+func (t *TrackedOrder) Acknowledge(id string) error {
+	if t.State != OrderPendingNew {
+		return fmt.Errorf("order %v acknowledged while %v", t.key(), t.State)
+	}
+	t.Id = id
+	return t.transition(OrderOpen)
+}
+
+// Fill records size filled at price. A fill during a pending cancel
+// leaves the cancel pending unless it completes the order.
+func (t *TrackedOrder) Fill(size float64, price float64) error {
+	if size <= 0 {
+		return fmt.Errorf("order %v filled for %v", t.key(), size)
+	}
+	if size > t.Remaining() + 1e-9 {
+		return fmt.Errorf("order %v filled for %v with only %v left", t.key(), size, t.Remaining())
+	}
+	to := OrderPartiallyFilled
+	if size >= t.Remaining() - 1e-9 {
+		to = OrderFilled
+	} else if t.State == OrderPendingCancel {
+		to = OrderPendingCancel
+	}
+	if err := t.transition(to); err != nil {
+		return err
+	}
+	t.FilledSize = roundPlus(t.FilledSize + size, 8)
+	t.FilledValue += size * price
+	return nil
+}
+
+// Resize records the exchange shrinking what's left of the order.
+func (t *TrackedOrder) Resize(remaining float64) error {
+	if !t.IsLive() || t.State == OrderPendingNew {
+		return fmt.Errorf("order %v resized while %v", t.key(), t.State)
+	}
+	if remaining < 0 || remaining > t.Remaining() + 1e-9 {
+		return fmt.Errorf("order %v resized from %v to %v", t.key(), t.Remaining(), remaining)
+	}
+	t.Size = roundPlus(t.FilledSize + remaining, 8)
+	t.Updated = time.Now()
+	return nil
+}
+
+func (t *TrackedOrder) RequestCancel() error {
+	return t.transition(OrderPendingCancel)
+}
+
+// CancelFailed puts an order whose cancel didn't go through back to
+// resting.
+func (t *TrackedOrder) CancelFailed() error {
+	if t.State != OrderPendingCancel {
+		return fmt.Errorf("order %v cancel failed while %v", t.key(), t.State)
+	}
+	if t.FilledSize > 0 {
+		return t.transition(OrderPartiallyFilled)
+	}
+	return t.transition(OrderOpen)
+}
+
+func (t *TrackedOrder) Cancel() error {
+	return t.transition(OrderCanceled)
+}
+
+func (t *TrackedOrder) Reject() error {
+	return t.transition(OrderRejected)
+}
+
+// Order is what's left of the order as the exchange would describe it.
+func (t *TrackedOrder) Order() exchange.Order {
+	return exchange.Order{
+		Id: t.Id,
+		ClientOID: t.ClientOID,
+		ProductId: t.ProductId,
+		Side: t.Side,
+		Price: t.Price,
+		Size: t.Remaining(),
+	}
+}
+
+func (t *TrackedOrder) key() string {
+	if t.Id != "" {
+		return t.Id
+	}
+	return t.ClientOID
+}
+
+func (t *TrackedOrder) String() string {
+	return fmt.Sprintf("%v %v %v @ %v (%v, filled %v)", t.key(), t.Side, t.Size, t.Price, t.State, t.FilledSize)
+}
+
+// sortedOrders returns the orders as the exchange would describe them,
+// those still pending first and by price within each group.
+func sortedOrders(orders []*TrackedOrder) []exchange.Order {
+	sorted := make([]*TrackedOrder, len(orders))
+	copy(sorted, orders)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := sorted[i].State == OrderPendingNew, sorted[j].State == OrderPendingNew
+		if pi != pj {
+			return pi
+		}
+		return sorted[i].Price < sorted[j].Price
+	})
+	result := make([]exchange.Order, len(sorted))
+	for i, t := range sorted {
+		result[i] = t.Order()
+	}
+	return result
+}
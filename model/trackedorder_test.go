@@ -0,0 +1,68 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	exchange "github.com/preichenberger/go-coinbase-exchange"
+)
+
+type TrackedOrderTestSuite struct {
+	suite.Suite
+	t *TrackedOrder
+}
+
+func (s *TrackedOrderTestSuite) SetupTest() {
+	s.t = NewTrackedOrder(exchange.Order{ClientOID: "c1", Side: "buy", Price: 100.0, Size: 1.0})
+}
+
+func (s *TrackedOrderTestSuite) TestLifecycle() {
+	assert.Equal(s.T(), OrderPendingNew, s.t.State)
+	assert.NoError(s.T(), s.t.Acknowledge("1"))
+	assert.NoError(s.T(), s.t.Fill(0.25, 100.0))
+	assert.NoError(s.T(), s.t.Fill(0.25, 99.0))
+	assert.Equal(s.T(), OrderPartiallyFilled, s.t.State)
+	assert.Equal(s.T(), 0.5, s.t.Remaining())
+	assert.Equal(s.T(), 99.5, s.t.AvgPrice())
+
+	assert.NoError(s.T(), s.t.RequestCancel())
+	assert.NoError(s.T(), s.t.Fill(0.1, 100.0))
+	assert.Equal(s.T(), OrderPendingCancel, s.t.State)
+	assert.NoError(s.T(), s.t.Cancel())
+	assert.False(s.T(), s.t.IsLive())
+
+	states := make([]OrderState, 0)
+	for _, tr := range s.t.Transitions {
+		states = append(states, tr.To)
+	}
+	assert.Equal(s.T(), []OrderState{OrderOpen, OrderPartiallyFilled, OrderPartiallyFilled, OrderPendingCancel, OrderPendingCancel, OrderCanceled}, states)
+}
+
+func (s *TrackedOrderTestSuite) TestRejectsImpossibleTransitions() {
+	assert.Error(s.T(), s.t.RequestCancel())
+	assert.Error(s.T(), s.t.Resize(0.5))
+	assert.NoError(s.T(), s.t.Acknowledge("1"))
+	assert.Error(s.T(), s.t.Acknowledge("1"))
+	assert.Error(s.T(), s.t.Fill(1.5, 100.0))
+	assert.Error(s.T(), s.t.Reject())
+	assert.NoError(s.T(), s.t.Fill(1.0, 100.0))
+	assert.Equal(s.T(), OrderFilled, s.t.State)
+
+	assert.Error(s.T(), s.t.Cancel())
+	assert.Error(s.T(), s.t.Fill(0.1, 100.0))
+	assert.Equal(s.T(), OrderFilled, s.t.State)
+	assert.Equal(s.T(), 1.0, s.t.FilledSize)
+	assert.Equal(s.T(), 2, len(s.t.Transitions))
+}
+
+func (s *TrackedOrderTestSuite) TestCancelFailed() {
+	assert.NoError(s.T(), s.t.Acknowledge("1"))
+	assert.NoError(s.T(), s.t.RequestCancel())
+	assert.NoError(s.T(), s.t.CancelFailed())
+	assert.Equal(s.T(), OrderOpen, s.t.State)
+	assert.Error(s.T(), s.t.CancelFailed())
+}
+
+func TestTrackedOrderSuite(t *testing.T) {
+	suite.Run(t, new(TrackedOrderTestSuite))
+}
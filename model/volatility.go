@@ -0,0 +1,96 @@
+package model
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TradeStats summarizes trading over one window. Volatility is the
+// standard deviation of log returns per root second, TradeRate is in
+// trades per second and SignedVolume in BTC per second, positive when
+// takers are mostly buying.
+type TradeStats struct {
+	Window time.Duration
+	Volatility float64
+	TradeRate float64
+	SignedVolume float64
+}
+
+// VolatilityEstimator keeps exponentially decaying estimates of realized
+// variance, trade arrival rate and signed volume, one per window, updated
+// from every trade. Each trade adds to the estimates and its weight decays
+// with the window as its time constant.
+type VolatilityEstimator struct {
+	sync.RWMutex
+	windows []time.Duration
+	lastPrice float64
+	lastTime time.Time
+	variance []float64
+	rate []float64
+	flow []float64
+}
+
+func NewVolatilityEstimator(windows []time.Duration) *VolatilityEstimator {
+	return &VolatilityEstimator{
+		windows: windows,
+		variance: make([]float64, len(windows)),
+		rate: make([]float64, len(windows)),
+		flow: make([]float64, len(windows)),
+	}
+}
+
+// Update records a trade. takerSide is the side of the order that took
+// liquidity, which is the opposite of a match message's side.
+func (v *VolatilityEstimator) Update(price float64, size float64, takerSide Side, t time.Time) {
+	if price <= 0 {
+		return
+	}
+	v.Lock()
+	defer v.Unlock()
+	r := 0.0
+	if v.lastPrice > 0 {
+		r = math.Log(price / v.lastPrice)
+	}
+	signed := size
+	if takerSide == Sell {
+		signed = -size
+	}
+	for i, w := range v.windows {
+		decay := v.decay(i, t)
+		seconds := w.Seconds()
+		v.variance[i] = v.variance[i] * decay + r * r / seconds
+		v.rate[i] = v.rate[i] * decay + 1 / seconds
+		v.flow[i] = v.flow[i] * decay + signed / seconds
+	}
+	v.lastPrice = price
+	if t.After(v.lastTime) {
+		v.lastTime = t
+	}
+}
+
+// Stats returns the estimates for each window, decayed up to now.
+func (v *VolatilityEstimator) Stats(now time.Time) []TradeStats {
+	v.RLock()
+	defer v.RUnlock()
+	stats := make([]TradeStats, len(v.windows))
+	for i, w := range v.windows {
+		decay := v.decay(i, now)
+		stats[i] = TradeStats{
+			Window: w,
+			Volatility: math.Sqrt(v.variance[i] * decay),
+			TradeRate: v.rate[i] * decay,
+			SignedVolume: v.flow[i] * decay,
+		}
+	}
+	return stats
+}
+
+// decay is how much the estimates for window i fade between the last
+// trade and t. The caller must hold the lock.
+func (v *VolatilityEstimator) decay(i int, t time.Time) float64 {
+	if v.lastTime.IsZero() || !t.After(v.lastTime) {
+		return 1
+	}
+	return math.Exp(-t.Sub(v.lastTime).Seconds() / v.windows[i].Seconds())
+}
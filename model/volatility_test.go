@@ -0,0 +1,26 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestVolatilityEstimator(t *testing.T) {
+	start := time.Now()
+	v := NewVolatilityEstimator([]time.Duration{10 * time.Second, 100 * time.Second})
+	v.Update(100.0, 1.0, Buy, start)
+	v.Update(101.0, 2.0, Sell, start)
+
+	stats := v.Stats(start)
+	r := math.Log(101.0 / 100.0)
+	assert.InDelta(t, math.Sqrt(r * r / 10), stats[0].Volatility, 1e-9)
+	assert.InDelta(t, math.Sqrt(r * r / 100), stats[1].Volatility, 1e-9)
+	assert.InDelta(t, 0.2, stats[0].TradeRate, 1e-9)
+	assert.InDelta(t, -0.1, stats[0].SignedVolume, 1e-9)
+
+	later := v.Stats(start.Add(10 * time.Second))
+	assert.InDelta(t, 0.2 / math.E, later[0].TradeRate, 1e-9)
+	assert.InDelta(t, 0.02 * math.Exp(-0.1), later[1].TradeRate, 1e-9)
+}
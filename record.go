@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"github.com/sirsean/marketmaker/config"
+	"github.com/sirsean/marketmaker/model"
+)
+
+// record writes the raw feed and periodic snapshots to disk without
+// trading, for debugging incidents and for replays. Each product is
+// recorded to its own files.
+func record() {
+	maxSize := config.Get().Record.MaxSizeMb * 1024 * 1024
+	productIds := config.Get().Products()
+	recorders := make(map[string]*model.Recorder)
+	for _, id := range productIds {
+		productId := id
+		recorders[id] = model.NewRecorder(config.Get().Record.Dir, id, maxSize, func() (*model.OrderBook, error) {
+			return model.DownloadOrderBook(productId)
+		})
+	}
+
+	sigChan = make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	signal.Notify(sigChan, syscall.SIGTERM)
+	go func(c chan os.Signal) {
+		<-c
+		for id, r := range recorders {
+			if err := r.Close(); err != nil {
+				log.Printf("failed to close %v recording: %v", id, err)
+			}
+		}
+		os.Exit(0)
+	}(sigChan)
+
+	superviseFeed(feedUrl, publicSubscription(productIds), func(raw []byte) {
+		var header struct {
+			ProductId string `json:"product_id"`
+		}
+		json.Unmarshal(raw, &header)
+		recorder, ok := recorders[header.ProductId]
+		if !ok {
+			return
+		}
+		if err := recorder.WriteMessage(raw); err != nil {
+			log.Printf("failed to record message: %v", err)
+		}
+	}, func() {
+		for _, r := range recorders {
+			r.Rotate()
+		}
+	})
+}